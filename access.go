@@ -0,0 +1,169 @@
+package herd
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/elemir/herd/internal"
+)
+
+// access is a set of data a system reads and writes. Systems with conflicting access never run concurrently
+type access struct {
+	reads  map[any]struct{}
+	writes map[any]struct{}
+
+	// opaque is set when a holder has a value whose access can't be derived, e.g. a function
+	opaque bool
+}
+
+type resourceKey struct {
+	typ reflect.Type
+}
+
+type accessor interface {
+	access(a *access)
+}
+
+var accessorType = internal.TypeOf[accessor]()
+
+func newAccess() *access {
+	return &access{
+		reads:  make(map[any]struct{}),
+		writes: make(map[any]struct{}),
+	}
+}
+
+func (a *access) read(key any) {
+	a.reads[key] = struct{}{}
+}
+
+func (a *access) write(key any) {
+	a.writes[key] = struct{}{}
+}
+
+// conflicts reports whether one of the accesses writes data that the other one reads or writes.
+// Nil access is exclusive and conflicts with everything
+func (a *access) conflicts(other *access) bool {
+	if a == nil || other == nil {
+		return true
+	}
+
+	for key := range a.writes {
+		if _, ok := other.reads[key]; ok {
+			return true
+		}
+		if _, ok := other.writes[key]; ok {
+			return true
+		}
+	}
+
+	for key := range other.writes {
+		if _, ok := a.reads[key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collect derives access from the holder. Queries, events and event readers describe their own access,
+// pointers to resources of the App are resource writes, and structs, arrays, slices, maps and interfaces are walked
+// through. Functions and channels may use anything, so they make access opaque.
+// Manager is a resource too, so systems holding it never run concurrently and queue commands in schedule order
+func (a *access) collect(app *App, holder any) {
+	val := reflect.ValueOf(holder)
+	if !val.IsValid() {
+		return
+	}
+
+	a.collectValue(app, addressable(val), make(map[unsafe.Pointer]struct{}))
+}
+
+func (a *access) collectValue(app *App, val reflect.Value, visited map[unsafe.Pointer]struct{}) {
+	typ := val.Type()
+
+	switch {
+	case typ.Implements(accessorType):
+		if typ.Kind() != reflect.Pointer || !val.IsNil() {
+			val.Interface().(accessor).access(a)
+		}
+	case typ.Kind() == reflect.Pointer:
+		if _, ok := app.resources[typ.Elem()]; ok {
+			a.write(resourceKey{typ.Elem()})
+			return
+		}
+
+		if val.IsNil() {
+			return
+		}
+		if _, ok := visited[val.UnsafePointer()]; ok {
+			return
+		}
+		visited[val.UnsafePointer()] = struct{}{}
+
+		a.collectValue(app, val.Elem(), visited)
+	case typ.Kind() == reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Field(i)
+			if !field.CanInterface() {
+				field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+			}
+
+			a.collectValue(app, field, visited)
+		}
+	case typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice:
+		// elements of basic types can't hold anything, so large buffers aren't walked
+		if typ.Elem().Kind() <= reflect.Complex128 || typ.Elem().Kind() == reflect.String {
+			return
+		}
+
+		for i := 0; i < val.Len(); i++ {
+			a.collectValue(app, val.Index(i), visited)
+		}
+	case typ.Kind() == reflect.Map:
+		iter := val.MapRange()
+		for iter.Next() {
+			a.collectValue(app, addressable(iter.Key()), visited)
+			a.collectValue(app, addressable(iter.Value()), visited)
+		}
+	case typ.Kind() == reflect.Interface:
+		if !val.IsNil() {
+			a.collectValue(app, addressable(val.Elem()), visited)
+		}
+	case typ.Kind() == reflect.Func || typ.Kind() == reflect.Chan || typ.Kind() == reflect.UnsafePointer:
+		if !val.IsNil() {
+			a.opaque = true
+		}
+	}
+}
+
+// addressable copies val to an addressable value, so unexported fields of structs may be read too
+func addressable(val reflect.Value) reflect.Value {
+	copied := reflect.New(val.Type()).Elem()
+	copied.Set(val)
+
+	return copied
+}
+
+func (q Query[T]) access(a *access) {
+	// iterator keeps its own state, so the systems sharing a query never run concurrently
+	a.write(q.iterator)
+
+	for _, term := range q.terms {
+		switch term.Access {
+		case internal.Copy, internal.Direct, internal.Optional:
+			a.write(term.Component)
+		case internal.Added, internal.Changed:
+			a.read(term.Component)
+		}
+	}
+}
+
+func (e *Events[T]) access(a *access) {
+	a.write(resourceKey{internal.TypeOf[Events[T]]()})
+}
+
+func (r *EventReader[T]) access(a *access) {
+	a.write(r)
+	a.read(resourceKey{internal.TypeOf[Events[T]]()})
+}
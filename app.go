@@ -1,97 +1,225 @@
 package herd
 
 import (
-	"image"
-
-	"github.com/hajimehoshi/ebiten/v2"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
 
 	"github.com/elemir/herd/internal"
 )
 
-type EntityID int
-
 // Startup is a initilize system that updates after App started. Startup system should return (true, nil) when initialisation finished successul
 type Startup func() (bool, error)
 
 // System is a basic system that updates every tick after App initialization is finished
 type System func() error
 
-// Renderer is a system that draws something every frame
-type Renderer func(*ebiten.Image)
+// Shutdown is a teardown system that runs once when App stops
+type Shutdown func() error
 
-type startupInfo struct {
-	system   Startup
-	finished bool
-}
+// ErrClosed is returned by Update after App is closed
+var ErrClosed = errors.New("app is closed")
 
-// An App incapsulated all game logic and rendering. An App provides methods to adding systems and renderers and also implements ebiten.Game interface
+// An App incapsulated all game logic. An App provides methods to adding systems and runs them on every Update.
+// App doesn't depend on any game engine, ebitengine package adds rendering with Ebitengine and headless package runs App
+// without display
 type App struct {
 	alreadyUpdated bool
-	lastEntity     EntityID
+	entities       *entities
+
+	pass        uint64
+	workers     int
+	spawnPolicy SpawnPolicy
+	onCommand   func(error)
+
+	clock      Clock
+	lastUpdate time.Time
+	time       *Time
+	fixedTime  *FixedTime
+
+	storage   *internal.Storage[EntityID]
+	schedules []schedule
+
+	startups        []startupInfo
+	startupNames    map[string]int
+	startupsChecked bool
+	initialized     bool
 
-	storage   internal.Storage[EntityID]
-	systems   []System
-	renderers []Renderer
+	shutdowns []Shutdown
+	closed    bool
 
-	startups    []startupInfo
-	initialized bool
+	resources map[reflect.Type]any
 
+	bundles   map[reflect.Type]*internal.Iterator[EntityID]
+	bundlesMu sync.Mutex
+	events    []interface{ update() }
+
+	states     map[reflect.Type]stateMachine
+	stateOrder []reflect.Type
+
+	// Manager and SystemInfo are also available as resources
 	Manager    *Manager
 	SystemInfo *SystemInfo
 }
 
 // NewApp returns a new App instance
 func NewApp() *App {
+	entities := &entities{}
+	manager := newManager(entities)
+	info := &SystemInfo{}
+	appTime := &Time{
+		Scale: 1,
+	}
+	fixedTime := &FixedTime{
+		Step:     time.Second / 60,
+		MaxSteps: 5,
+	}
+
 	app := &App{
-		storage:    internal.NewStorage[EntityID](),
-		Manager:    newManager(),
-		SystemInfo: &SystemInfo{},
+		entities:  entities,
+		storage:   internal.NewStorage[EntityID](),
+		schedules: newSchedules(),
+		workers:   runtime.GOMAXPROCS(0),
+		resources: map[reflect.Type]any{
+			internal.TypeOf[Manager]():    manager,
+			internal.TypeOf[SystemInfo](): info,
+			internal.TypeOf[Time]():       appTime,
+			internal.TypeOf[FixedTime]():  fixedTime,
+		},
+		states:     make(map[reflect.Type]stateMachine),
+		bundles:    make(map[reflect.Type]*internal.Iterator[EntityID]),
+		clock:      realClock{},
+		onCommand:  logCommandError,
+		time:       appTime,
+		fixedTime:  fixedTime,
+		Manager:    manager,
+		SystemInfo: info,
 	}
 
 	return app
 }
 
+// AddSystems adds systems to the Update stage
 func (app *App) AddSystems(systems ...System) error {
+	return app.AddSystemsTo(Update, systems...)
+}
+
+// AddSystemsTo adds systems to the stage. Systems of a stage run in the order they were added
+func (app *App) AddSystemsTo(stage Stage, systems ...System) error {
+	if !stage.valid() {
+		return fmt.Errorf("add systems to unknown stage %s", stage)
+	}
+
 	for _, system := range systems {
-		app.systems = append(app.systems, system)
+		app.schedules[stage].add(Configure(system))
 	}
 
 	return nil
 }
 
+// AddConfiguredSystems adds configured systems to the stage. Systems are sorted by their constraints before the stage runs first time
+func (app *App) AddConfiguredSystems(stage Stage, configs ...*SystemConfig) error {
+	if !stage.valid() {
+		return fmt.Errorf("add systems to unknown stage %s", stage)
+	}
+
+	app.schedules[stage].add(configs...)
+
+	return nil
+}
+
+// AddStartups adds initialization systems which run every tick until they finish, before any other system
 func (app *App) AddStartups(startups ...Startup) error {
 	for _, startup := range startups {
-		app.startups = append(app.startups, startupInfo{
-			system: startup,
-		})
+		if err := app.AddConfiguredStartups(ConfigureStartup(startup)); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (app *App) AddRenderers(renderers ...Renderer) error {
-	for _, renderer := range renderers {
-		app.renderers = append(app.renderers, renderer)
-	}
+// AddShutdowns adds teardown systems. Shutdowns run once in reverse order when a system returns an error
+// (including termination errors of the game engine) or when App.Close is called
+func (app *App) AddShutdowns(shutdowns ...Shutdown) error {
+	app.shutdowns = append(app.shutdowns, shutdowns...)
 
 	return nil
 }
 
+// Update runs a single tick of the App. If a system fails, shutdowns run and their errors are joined with the original
+// one, so App is closed. If queued commands can't be applied, e.g. a spawn fails with FailOnSpawnError policy, only
+// the rest of the tick is skipped and the error is returned, while App stays open
 func (app *App) Update() error {
-	if !app.initialized {
-		initialized := true
+	if app.closed {
+		return ErrClosed
+	}
 
-		for i, startup := range app.startups {
-			if startup.finished {
-				continue
-			}
+	if err := app.update(); err != nil {
+		var failed *tickError
+		if errors.As(err, &failed) {
+			return failed.err
+		}
 
-			finished, err := startup.system()
-			if err != nil {
-				return err
-			}
-			app.startups[i].finished = finished
-			initialized = initialized && finished
+		return app.shutdown(err)
+	}
+
+	return nil
+}
+
+// tickError fails only the current tick, unlike errors of systems which close App
+type tickError struct {
+	err error
+}
+
+func (e *tickError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tickError) Unwrap() error {
+	return e.err
+}
+
+// Close runs shutdowns unless they already ran
+func (app *App) Close() error {
+	if app.closed {
+		return nil
+	}
+
+	return app.shutdown(nil)
+}
+
+func (app *App) shutdown(err error) error {
+	app.closed = true
+
+	errs := []error{err}
+	for i := len(app.shutdowns) - 1; i >= 0; i-- {
+		errs = append(errs, app.shutdowns[i]())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (app *App) update() error {
+	now := app.clock.Now()
+	if app.lastUpdate.IsZero() {
+		app.lastUpdate = now
+	}
+	app.time.advance(now.Sub(app.lastUpdate))
+	app.lastUpdate = now
+
+	for _, events := range app.events {
+		events.update()
+	}
+
+	if !app.initialized {
+		initialized, err := app.runStartups(now)
+		if err != nil {
+			return err
 		}
 
 		app.initialized = initialized
@@ -100,39 +228,145 @@ func (app *App) Update() error {
 		}
 	}
 
-	for _, system := range app.systems {
-		if err := system(); err != nil {
-			return err
-		}
+	// commands queued by OnEnter and OnExit systems are applied with the commands of PreUpdate stage
+	if err := app.transition(); err != nil {
+		return err
 	}
 
-	for _, bundle := range app.Manager.spawnQueue {
-		id := app.newEntity()
-		if err := app.storage.Add(id, bundle); err != nil {
-			return nil
+	for i := range app.schedules {
+		runs := 1
+		if Stage(i) == FixedUpdate {
+			runs = app.fixedTime.steps(app.time.Delta)
+		}
+
+		for ; runs > 0; runs-- {
+			if err := app.runStage(Stage(i)); err != nil {
+				return err
+			}
 		}
 	}
 
-	app.Manager.clear()
+	return nil
+}
+
+// SetSpawnPolicy sets what App does when a queued bundle can't be spawned. FailOnSpawnError is used by default
+func (app *App) SetSpawnPolicy(policy SpawnPolicy) {
+	app.spawnPolicy = policy
+}
+
+// SetCommandErrorHandler sets a function which gets CommandError of every skipped command and SpawnError of every
+// skipped spawn. By default errors are written to the standard logger
+func (app *App) SetCommandErrorHandler(handler func(error)) {
+	app.onCommand = handler
+}
+
+func logCommandError(err error) {
+	log.Printf("herd: skip command: %v", err)
+}
+
+// SetClock replaces the source of real time used for Time resource, FixedUpdate stage and time based run conditions
+func (app *App) SetClock(clock Clock) {
+	app.clock = clock
+}
+
+func (app *App) runStage(stage Stage) error {
+	app.pass++
+	if err := app.schedules[stage].run(app, app.pass); err != nil {
+		return err
+	}
+
+	if err := app.flush(); err != nil {
+		return &tickError{err: err}
+	}
+
 	app.SystemInfo.Entities = app.storage.Count()
 
 	return nil
 }
 
-func (app *App) Draw(screen *ebiten.Image) {
-	for _, renderer := range app.renderers {
-		renderer(screen)
+func (app *App) flush() error {
+	app.storage.NextTick()
+
+	// commands targeting entities of skipped spawns are skipped too
+	var skipped map[EntityID]struct{}
+
+	for i, cmd := range app.Manager.queue {
+		if _, ok := skipped[cmd.id]; ok && cmd.kind != spawnCommand {
+			continue
+		}
+
+		switch cmd.kind {
+		case spawnCommand:
+			if err := app.storage.Add(cmd.id, cmd.value); err != nil {
+				app.entities.release(cmd.id)
+				err = &SpawnError{
+					Entity: cmd.id,
+					Bundle: reflect.TypeOf(cmd.value),
+					Err:    err,
+				}
+
+				if app.spawnPolicy == SkipSpawnErrors {
+					app.onCommand(err)
+					if skipped == nil {
+						skipped = make(map[EntityID]struct{})
+					}
+					skipped[cmd.id] = struct{}{}
+					continue
+				}
+
+				app.discard(i + 1)
+				return err
+			}
+		case despawnCommand:
+			if err := app.storage.Remove(cmd.id); err != nil {
+				app.skip(cmd.id, fmt.Errorf("despawn entity %s: %w", cmd.id, err))
+				continue
+			}
+			app.entities.release(cmd.id)
+		case insertCommand:
+			if err := app.storage.Insert(cmd.id, cmd.value); err != nil {
+				app.skip(cmd.id, fmt.Errorf("insert component %T to entity %s: %w", cmd.value, cmd.id, err))
+			}
+		case removeCommand:
+			if err := app.storage.RemoveComponent(cmd.id, cmd.typ); err != nil {
+				app.skip(cmd.id, fmt.Errorf("remove component %s from entity %s: %w", cmd.typ, cmd.id, err))
+			}
+		case stateCommand:
+			machine, ok := app.states[cmd.typ]
+			if !ok {
+				app.skip(0, fmt.Errorf("set state %s: state is not added", cmd.typ))
+				continue
+			}
+			machine.request(cmd.value)
+		}
 	}
-}
 
-func (app *App) Layout(w, h int) (int, int) {
-	app.SystemInfo.Bounds = image.Rect(0, 0, w, h)
+	app.Manager.clear()
 
-	return w, h
+	return nil
 }
 
-func (app *App) newEntity() EntityID {
-	app.lastEntity++
+// skip reports a command which can't be applied
+func (app *App) skip(id EntityID, err error) {
+	app.onCommand(&CommandError{
+		Entity: id,
+		Err:    err,
+	})
+}
+
+// discard clears the queue after a failed command, releasing IDs reserved by spawns which are not applied
+func (app *App) discard(from int) {
+	for _, cmd := range app.Manager.queue[from:] {
+		if cmd.kind == spawnCommand {
+			app.entities.release(cmd.id)
+		}
+	}
+
+	app.Manager.clear()
+}
 
-	return app.lastEntity
+// Alive reports whether id refers to an entity that is spawned and not despawned yet. An ID returned by Manager.Spawn
+// refers to an alive entity only after the spawn is applied
+func (app *App) Alive(id EntityID) bool {
+	return app.entities.contains(id) && app.storage.Contains(id)
 }
@@ -1,9 +1,16 @@
 package herd
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/elemir/herd/internal"
 )
 
 type Bundle struct {
@@ -28,7 +35,7 @@ func TestQuery(t *testing.T) {
 	require.NoError(t, err)
 
 	var output []Bundle
-	err = app.AddSystems(func() error {
+	err = app.AddSystemsTo(PreUpdate, func() error {
 		query.ForEach(func(b *Bundle) {
 			output = append(output, *b)
 		})
@@ -60,7 +67,7 @@ func TestOneComponent(t *testing.T) {
 
 	var output []SimpleX
 
-	err = app.AddSystems(func() error {
+	err = app.AddSystemsTo(PreUpdate, func() error {
 		query.ForEach(func(x *SimpleX) {
 			output = append(output, *x)
 		})
@@ -95,7 +102,7 @@ func TestAnonymouseInline(t *testing.T) {
 	require.NoError(t, err)
 
 	var output []struct{ int }
-	err = app.AddSystems(func() error {
+	err = app.AddSystemsTo(PreUpdate, func() error {
 		query.ForEach(func(b *struct{ int }) {
 			output = append(output, *b)
 		})
@@ -153,3 +160,1584 @@ func TestStartups(t *testing.T) {
 	require.Equal(t, 2, firstStartupRunCount)
 	require.Equal(t, 1, secondStartupRunCount)
 }
+
+func TestDespawn(t *testing.T) {
+	app := NewApp()
+
+	for _, in := range []SimpleX{{10}, {23}, {45}} {
+		app.Manager.Spawn(in)
+	}
+
+	query, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+
+	var ids []EntityID
+	var output []SimpleX
+	err = app.AddSystems(func() error {
+		ids, output = nil, nil
+		query.Iterate(func(id EntityID, x *SimpleX) bool {
+			ids = append(ids, id)
+			output = append(output, *x)
+			return true
+		})
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = app.Update()
+	require.NoError(t, err)
+	require.Equal(t, 3, app.SystemInfo.Entities)
+
+	err = app.Update()
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+
+	removed := ids[0]
+	var despawned SimpleX
+	query.Iterate(func(id EntityID, x *SimpleX) bool {
+		if id == removed {
+			despawned = *x
+		}
+		return true
+	})
+	app.Manager.Despawn(removed)
+
+	err = app.Update()
+	require.NoError(t, err)
+	require.Equal(t, 2, app.SystemInfo.Entities)
+
+	err = app.Update()
+	require.NoError(t, err)
+	require.Len(t, output, 2)
+	require.NotContains(t, output, despawned)
+
+	var skipped []error
+	app.SetCommandErrorHandler(func(err error) {
+		skipped = append(skipped, err)
+	})
+
+	// stale despawns are reported, but the rest of the queue is applied
+	app.Manager.Despawn(removed)
+	app.Manager.Despawn(ids[0])
+	app.Manager.Despawn(ids[0])
+	app.Manager.Despawn(ids[1])
+	require.NoError(t, app.Update())
+	require.Empty(t, app.Manager.queue)
+	require.Zero(t, app.SystemInfo.Entities)
+	require.Len(t, skipped, 2)
+
+	var commandErr *CommandError
+	require.ErrorAs(t, skipped[0], &commandErr)
+	require.Equal(t, removed, commandErr.Entity)
+	require.ErrorIs(t, skipped[0], ErrEntityNotFound)
+	require.ErrorIs(t, skipped[1], ErrEntityNotFound)
+
+	require.NoError(t, app.Update())
+}
+
+func TestEntityGenerations(t *testing.T) {
+	app := NewApp()
+
+	require.False(t, app.Alive(0))
+
+	app.Manager.Spawn(SimpleX{1})
+	require.NoError(t, app.Update())
+
+	query, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+
+	ids := func() []EntityID {
+		var ids []EntityID
+		query.Iterate(func(id EntityID, _ *SimpleX) bool {
+			ids = append(ids, id)
+			return true
+		})
+		return ids
+	}
+
+	first := ids()
+	require.Len(t, first, 1)
+	require.True(t, app.Alive(first[0]))
+
+	app.Manager.Despawn(first[0])
+	require.NoError(t, app.Update())
+	require.False(t, app.Alive(first[0]))
+
+	app.Manager.Spawn(SimpleX{2})
+	require.NoError(t, app.Update())
+
+	second := ids()
+	require.Len(t, second, 1)
+	require.True(t, app.Alive(second[0]))
+	require.False(t, app.Alive(first[0]))
+	require.Equal(t, first[0].Index(), second[0].Index())
+	require.NotEqual(t, first[0].Generation(), second[0].Generation())
+
+	var skipped error
+	app.SetCommandErrorHandler(func(err error) {
+		skipped = err
+	})
+
+	app.Manager.Despawn(first[0])
+	require.NoError(t, app.Update())
+	require.ErrorIs(t, skipped, ErrEntityNotFound)
+	require.True(t, app.Alive(second[0]))
+}
+
+type Stunned struct {
+	Ticks int
+}
+
+type StunnedX struct {
+	X int
+	Stunned
+}
+
+func TestInsertRemove(t *testing.T) {
+	app := NewApp()
+
+	app.Manager.Spawn(SimpleX{1})
+	app.Manager.Spawn(SimpleX{2})
+	require.NoError(t, app.Update())
+
+	simple, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+
+	stunned, err := NewQuery[StunnedX](app)
+	require.NoError(t, err)
+
+	count := func() int {
+		var n int
+		stunned.ForEach(func(*StunnedX) {
+			n++
+		})
+		return n
+	}
+
+	var target EntityID
+	simple.Iterate(func(id EntityID, x *SimpleX) bool {
+		target = id
+		return x.X != 2
+	})
+	require.Zero(t, count())
+
+	app.Manager.Insert(target, Stunned{3})
+	require.NoError(t, app.Update())
+	require.Equal(t, 1, count())
+	stunned.ForEach(func(s *StunnedX) {
+		require.Equal(t, 2, s.X)
+		require.Equal(t, 3, s.Ticks)
+	})
+
+	Remove[Stunned](app.Manager, target)
+	require.NoError(t, app.Update())
+	require.Zero(t, count())
+
+	var skipped []error
+	app.SetCommandErrorHandler(func(err error) {
+		skipped = append(skipped, err)
+	})
+
+	Remove[Stunned](app.Manager, target)
+	app.Manager.Insert(target, nil)
+	app.Manager.Despawn(target)
+	app.Manager.Insert(target, Stunned{1})
+	require.NoError(t, app.Update())
+	require.Len(t, skipped, 3)
+	require.ErrorIs(t, skipped[0], ErrComponentNotFound)
+	require.ErrorIs(t, skipped[1], ErrNilComponent)
+	require.ErrorIs(t, skipped[2], ErrEntityNotFound)
+	require.False(t, app.Alive(target))
+}
+
+func TestComponentIdentity(t *testing.T) {
+	app := NewApp()
+
+	type Named struct {
+		Left  int `herd:"left"`
+		Right int `herd:"right"`
+		Name  string
+	}
+
+	app.Manager.Spawn(Bundle{10, "10"})
+	app.Manager.Spawn(Named{1, 2, "named"})
+
+	renamed, err := NewQuery[struct {
+		Value int
+		Label string
+	}](app)
+	require.NoError(t, err)
+
+	swapped, err := NewQuery[struct {
+		Right int `herd:"right"`
+		Left  int `herd:"left"`
+	}](app)
+	require.NoError(t, err)
+
+	_, err = NewQuery[struct {
+		A int
+		B int
+	}](app)
+	require.ErrorIs(t, err, internal.ErrDuplicateComponent)
+
+	require.NoError(t, app.Update())
+
+	var labels []string
+	renamed.ForEach(func(b *struct {
+		Value int
+		Label string
+	}) {
+		labels = append(labels, b.Label)
+	})
+	require.Equal(t, []string{"10"}, labels)
+
+	var sums []int
+	swapped.ForEach(func(b *struct {
+		Right int `herd:"right"`
+		Left  int `herd:"left"`
+	}) {
+		sums = append(sums, b.Left*10+b.Right)
+	})
+	require.Equal(t, []int{12}, sums)
+}
+
+func TestArchetypeMoves(t *testing.T) {
+	app := NewApp()
+
+	for i := 0; i < 100; i++ {
+		app.Manager.Spawn(Bundle{i, "x"})
+	}
+	require.NoError(t, app.Update())
+
+	query, err := NewQuery[Bundle](app)
+	require.NoError(t, err)
+
+	stunned, err := NewQuery[struct {
+		X int
+		Stunned
+	}](app)
+	require.NoError(t, err)
+
+	query.Iterate(func(id EntityID, b *Bundle) bool {
+		switch {
+		case b.X%2 == 0:
+			app.Manager.Despawn(id)
+		case b.X%3 == 0:
+			app.Manager.Insert(id, Stunned{b.X})
+		}
+		return true
+	})
+	require.NoError(t, app.Update())
+	require.Equal(t, 50, app.SystemInfo.Entities)
+
+	var sum int
+	query.ForEach(func(b *Bundle) {
+		require.Equal(t, 1, b.X%2)
+		require.Equal(t, "x", b.Y)
+		sum += b.X
+	})
+	require.Equal(t, 2500, sum)
+
+	var count int
+	stunned.ForEach(func(s *struct {
+		X int
+		Stunned
+	}) {
+		require.Equal(t, s.X, s.Ticks)
+		count++
+	})
+	require.Equal(t, 17, count)
+}
+
+type PointerBundle struct {
+	X *int
+	Y *string
+}
+
+func TestPointerQuery(t *testing.T) {
+	app := NewApp()
+
+	for _, in := range []Bundle{{10, "10"}, {23, "23"}} {
+		app.Manager.Spawn(in)
+	}
+	require.NoError(t, app.Update())
+
+	pointers, err := NewQuery[PointerBundle](app)
+	require.NoError(t, err)
+
+	var kept []*int
+	pointers.ForEach(func(b *PointerBundle) {
+		*b.X *= 2
+		kept = append(kept, b.X)
+	})
+	for _, x := range kept {
+		*x++
+	}
+
+	values, err := NewQuery[Bundle](app)
+	require.NoError(t, err)
+
+	var output []Bundle
+	values.ForEach(func(b *Bundle) {
+		output = append(output, *b)
+	})
+	require.ElementsMatch(t, []Bundle{{21, "10"}, {47, "23"}}, output)
+
+	_, err = NewQuery[struct {
+		X  int
+		PX *int
+	}](app)
+	require.ErrorIs(t, err, internal.ErrDuplicateComponent)
+
+	// pointers can't be components, since pointer fields refer to components stored by value
+	var skipped error
+	app.SetCommandErrorHandler(func(err error) {
+		skipped = err
+	})
+
+	v := 1
+	app.Manager.Spawn(struct{ P *int }{&v})
+	require.ErrorIs(t, app.flush(), ErrPointerComponent)
+
+	var id EntityID
+	values.Iterate(func(i EntityID, _ *Bundle) bool {
+		id = i
+		return false
+	})
+	app.Manager.Insert(id, &Score{})
+	require.NoError(t, app.flush())
+	require.ErrorIs(t, skipped, ErrPointerComponent)
+	require.ErrorContains(t, skipped, "store herd.Score by value")
+}
+
+func TestFilters(t *testing.T) {
+	app := NewApp()
+
+	app.Manager.Spawn(Bundle{1, "stunned"})
+	app.Manager.Spawn(Bundle{2, "free"})
+	app.Manager.Spawn(SimpleX{3})
+	require.NoError(t, app.Update())
+
+	all, err := NewQuery[Bundle](app)
+	require.NoError(t, err)
+	all.Iterate(func(id EntityID, b *Bundle) bool {
+		if b.Y == "stunned" {
+			app.Manager.Insert(id, Stunned{5})
+		}
+		return true
+	})
+	require.NoError(t, app.Update())
+
+	type WithStunned struct {
+		X int
+		With[Stunned]
+	}
+	type WithoutStunned struct {
+		X int
+		Without[Stunned]
+	}
+	type OptionalStunned struct {
+		X       int
+		Stunned Optional[Stunned]
+	}
+
+	with, err := NewQuery[WithStunned](app)
+	require.NoError(t, err)
+	without, err := NewQuery[WithoutStunned](app)
+	require.NoError(t, err)
+	optional, err := NewQuery[OptionalStunned](app)
+	require.NoError(t, err)
+
+	var withX []int
+	with.ForEach(func(b *WithStunned) {
+		withX = append(withX, b.X)
+	})
+	require.ElementsMatch(t, []int{1}, withX)
+
+	var withoutX []int
+	without.ForEach(func(b *WithoutStunned) {
+		withoutX = append(withoutX, b.X)
+	})
+	require.ElementsMatch(t, []int{2, 3}, withoutX)
+
+	ticks := map[int]int{}
+	optional.ForEach(func(b *OptionalStunned) {
+		ticks[b.X] = -1
+		if s, ok := b.Stunned.Get(); ok {
+			ticks[b.X] = s.Ticks
+			s.Ticks--
+		}
+	})
+	require.Equal(t, map[int]int{1: 5, 2: -1, 3: -1}, ticks)
+
+	optional.ForEach(func(b *OptionalStunned) {
+		if s, ok := b.Stunned.Get(); ok {
+			require.Equal(t, 4, s.Ticks)
+		}
+	})
+
+	_, err = NewQuery[struct {
+		S Stunned
+		O Optional[Stunned]
+	}](app)
+	require.ErrorIs(t, err, internal.ErrDuplicateComponent)
+
+	_, err = NewQuery[struct{ O *Optional[Stunned] }](app)
+	require.ErrorContains(t, err, "filters should be used by value")
+	_, err = NewQuery[struct{ W *With[Stunned] }](app)
+	require.Error(t, err)
+}
+
+func TestChangeDetection(t *testing.T) {
+	app := NewApp()
+
+	type AddedX struct {
+		X int
+		Added[int]
+	}
+	type ChangedX struct {
+		X int
+		Changed[int]
+	}
+
+	added, err := NewQuery[AddedX](app)
+	require.NoError(t, err)
+	changed, err := NewQuery[ChangedX](app)
+	require.NoError(t, err)
+	values, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+	pointers, err := NewQuery[struct{ X *int }](app)
+	require.NoError(t, err)
+
+	collectAdded := func() []int {
+		var xs []int
+		added.ForEach(func(b *AddedX) {
+			xs = append(xs, b.X)
+		})
+		return xs
+	}
+	collectChanged := func() []int {
+		var xs []int
+		changed.ForEach(func(b *ChangedX) {
+			xs = append(xs, b.X)
+		})
+		return xs
+	}
+
+	app.Manager.Spawn(SimpleX{1})
+	app.Manager.Spawn(SimpleX{2})
+	require.NoError(t, app.Update())
+
+	require.ElementsMatch(t, []int{1, 2}, collectAdded())
+	require.ElementsMatch(t, []int{1, 2}, collectChanged())
+	require.Empty(t, collectAdded())
+	require.Empty(t, collectChanged())
+
+	values.ForEach(func(x *SimpleX) {
+		if x.X == 2 {
+			x.X = 20
+		}
+	})
+	require.Empty(t, collectAdded())
+	require.ElementsMatch(t, []int{20}, collectChanged())
+
+	app.Manager.Spawn(SimpleX{3})
+	require.NoError(t, app.Update())
+
+	pointers.ForEach(func(b *struct{ X *int }) {})
+	require.ElementsMatch(t, []int{3}, collectAdded())
+	require.ElementsMatch(t, []int{1, 20, 3}, collectChanged())
+	require.Empty(t, collectChanged())
+}
+
+type Score struct {
+	Value int
+}
+
+func TestResources(t *testing.T) {
+	app := NewApp()
+
+	info, err := Resource[SystemInfo](app)
+	require.NoError(t, err)
+	require.Same(t, app.SystemInfo, info)
+
+	manager, err := Resource[Manager](app)
+	require.NoError(t, err)
+	require.Same(t, app.Manager, manager)
+
+	_, err = Resource[Score](app)
+	require.ErrorIs(t, err, ErrResourceNotFound)
+	require.False(t, HasResource[Score](app))
+
+	InsertResource(app, Score{10})
+	score, err := Resource[Score](app)
+	require.NoError(t, err)
+	require.Equal(t, 10, score.Value)
+
+	score.Value++
+	InsertResource(app, Score{score.Value * 2})
+	same, err := Resource[Score](app)
+	require.NoError(t, err)
+	require.Same(t, score, same)
+	require.Equal(t, 22, same.Value)
+
+	require.NoError(t, RemoveResource[Score](app))
+	require.False(t, HasResource[Score](app))
+	require.ErrorIs(t, RemoveResource[Score](app), ErrResourceNotFound)
+}
+
+type Hit struct {
+	Damage int
+}
+
+func TestEvents(t *testing.T) {
+	app := NewApp()
+	AddEvent[Hit](app)
+
+	events, err := Resource[Events[Hit]](app)
+	require.NoError(t, err)
+
+	before, err := NewEventReader[Hit](app)
+	require.NoError(t, err)
+	after, err := NewEventReader[Hit](app)
+	require.NoError(t, err)
+
+	var send []int
+	var beforeSeen, afterSeen []int
+	err = app.AddSystems(
+		func() error {
+			before.ForEach(func(hit *Hit) {
+				beforeSeen = append(beforeSeen, hit.Damage)
+			})
+			return nil
+		},
+		func() error {
+			for _, damage := range send {
+				events.Send(Hit{damage})
+			}
+			send = nil
+			return nil
+		},
+		func() error {
+			after.ForEach(func(hit *Hit) {
+				afterSeen = append(afterSeen, hit.Damage)
+			})
+			return nil
+		},
+	)
+	require.NoError(t, err)
+
+	send = []int{1, 2}
+	require.NoError(t, app.Update())
+	require.Empty(t, beforeSeen)
+	require.Equal(t, []int{1, 2}, afterSeen)
+
+	send = []int{3}
+	require.NoError(t, app.Update())
+	require.Equal(t, []int{1, 2}, beforeSeen)
+	require.Equal(t, []int{1, 2, 3}, afterSeen)
+
+	require.NoError(t, app.Update())
+	require.Equal(t, []int{1, 2, 3}, beforeSeen)
+	require.Equal(t, []int{1, 2, 3}, afterSeen)
+
+	late, err := NewEventReader[Hit](app)
+	require.NoError(t, err)
+	events.Send(Hit{4})
+
+	var lateSeen []int
+	late.ForEach(func(hit *Hit) {
+		lateSeen = append(lateSeen, hit.Damage)
+	})
+	require.Equal(t, []int{4}, lateSeen)
+
+	stale, err := NewEventReader[Hit](app)
+	require.NoError(t, err)
+	stale.next = 0
+	require.NoError(t, app.Update())
+	require.NoError(t, app.Update())
+	require.NoError(t, app.Update())
+
+	var staleSeen []int
+	stale.ForEach(func(hit *Hit) {
+		staleSeen = append(staleSeen, hit.Damage)
+	})
+	require.Empty(t, staleSeen)
+
+	_, err = NewEventReader[Score](app)
+	require.ErrorIs(t, err, ErrResourceNotFound)
+}
+
+func TestStages(t *testing.T) {
+	app := NewApp()
+
+	query, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+
+	var order []string
+	var seen int
+	count := func() error {
+		seen = 0
+		query.ForEach(func(*SimpleX) {
+			seen++
+		})
+		return nil
+	}
+
+	require.NoError(t, app.AddSystemsTo(Last, func() error {
+		order = append(order, "last")
+		return nil
+	}))
+	require.NoError(t, app.AddSystems(func() error {
+		order = append(order, "update")
+		return count()
+	}))
+	require.NoError(t, app.AddSystemsTo(PostUpdate, func() error {
+		order = append(order, "post")
+		return nil
+	}))
+	require.NoError(t, app.AddSystemsTo(PreUpdate, func() error {
+		order = append(order, "pre")
+		app.Manager.Spawn(SimpleX{1})
+		return nil
+	}))
+	require.Error(t, app.AddSystemsTo(Stage(42)))
+
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"pre", "update", "post", "last"}, order)
+	require.Equal(t, 1, seen)
+	require.Equal(t, 1, app.SystemInfo.Entities)
+}
+
+func TestSystemOrder(t *testing.T) {
+	app := NewApp()
+
+	var order []string
+	system := func(name string) System {
+		return func() error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	err := app.AddConfiguredSystems(Update,
+		Configure(system("bounce")).Label("bounce").After("velocity"),
+		Configure(system("render")),
+		Configure(system("velocity")).Label("velocity").After("gravity"),
+		Configure(system("gravity")).Label("gravity"),
+		Configure(system("input")).Before("gravity"),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"render", "input", "gravity", "velocity", "bounce"}, order)
+
+	cyclic := NewApp()
+	err = cyclic.AddConfiguredSystems(PostUpdate,
+		Configure(system("a")).Label("a").After("c"),
+		Configure(system("b")).Label("b").After("a"),
+		Configure(system("c")).Label("c").After("b"),
+		Configure(system("d")).After("a"),
+	)
+	require.NoError(t, err)
+	require.EqualError(t, cyclic.Update(),
+		`sort systems of stage PostUpdate: systems have cyclic order: "a" -> "b" -> "c" -> "a"`)
+
+	unknown := NewApp()
+	require.NoError(t, unknown.AddConfiguredSystems(Update, Configure(system("a")).After("missing")))
+	require.ErrorContains(t, unknown.Update(), `system #0 should run after unknown system "missing"`)
+
+	duplicate := NewApp()
+	require.NoError(t, duplicate.AddConfiguredSystems(Update,
+		Configure(system("a")).Label("same"),
+		Configure(system("b")).Label("same"),
+	))
+	require.ErrorContains(t, duplicate.Update(), `systems #0 and #1 have the same label "same"`)
+
+	ambiguous := NewApp()
+	InsertResource(ambiguous, Score{})
+	scores, err := Resource[Score](ambiguous)
+	require.NoError(t, err)
+	require.NoError(t, ambiguous.AddConfiguredSystems(Update,
+		Configure(system("a")).Label("a").Access(scores),
+		Configure(system("b")).Label("b").Access(scores),
+		Configure(system("c")).Label("c").Access(scores).After("a", "b"),
+	))
+	require.EqualError(t, ambiguous.Update(), `sort systems of stage Update: systems "a" and "b" access the same data, `+
+		`but their order is ambiguous, add Before or After constraint`)
+
+	resolved := NewApp()
+	InsertResource(resolved, Score{})
+	scores, err = Resource[Score](resolved)
+	require.NoError(t, err)
+	require.NoError(t, resolved.AddConfiguredSystems(Update,
+		Configure(system("c")).Label("c").Access(scores).After("b"),
+		Configure(system("a")).Label("a").Access(scores),
+		Configure(system("b")).Label("b").Access(scores).After("a"),
+		Configure(system("unlabelled")).Access(scores),
+	))
+	order = nil
+	require.NoError(t, resolved.Update())
+	require.Equal(t, []string{"a", "b", "c", "unlabelled"}, order)
+}
+
+func TestRunConditions(t *testing.T) {
+	app := NewApp()
+
+	runs := map[string]int{}
+	system := func(name string) System {
+		return func() error {
+			runs[name]++
+			return nil
+		}
+	}
+
+	enabled := false
+	var groupChecks int
+	err := app.AddConfiguredSystems(Update,
+		Configure(system("always")),
+		Configure(system("enabled")).RunIf(func() bool { return enabled }),
+		Configure(system("third")).RunIf(EveryNTicks(app, 3)),
+		Configure(system("never")).RunIf(EveryNTicks(app, 0)),
+		Configure(system("hourly")).RunIf(Every(app, time.Hour)),
+		Configure(system("score")).RunIf(ResourceExists[Score](app)),
+		Configure(system("changed")).RunIf(ResourceChanged[Score](app)),
+	)
+	require.NoError(t, err)
+
+	err = app.AddConfiguredSystems(PostUpdate, Group(
+		Configure(system("first")),
+		Configure(system("second")),
+	).RunIf(func() bool {
+		groupChecks++
+		return true
+	})...)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, app.Update())
+	}
+	require.Equal(t, map[string]int{"always": 3, "third": 1, "hourly": 1, "first": 3, "second": 3}, runs)
+	require.Equal(t, 3, groupChecks)
+
+	enabled = true
+	InsertResource(app, Score{1})
+	require.NoError(t, app.Update())
+	require.NoError(t, app.Update())
+	require.Equal(t, 2, runs["enabled"])
+	require.Equal(t, 2, runs["score"])
+	require.Equal(t, 1, runs["changed"])
+
+	InsertResource(app, Score{2})
+	require.NoError(t, app.Update())
+	require.Equal(t, 2, runs["changed"])
+	require.Equal(t, 2, runs["third"])
+	require.Zero(t, runs["never"])
+}
+
+type Velocity struct {
+	X float64
+}
+
+type Gravity struct {
+	Value float64
+}
+
+type Thought struct {
+	Count int
+}
+
+type GravityBundle struct {
+	Vel     *Velocity
+	Gravity *Gravity
+}
+
+type gravitySystem struct {
+	query Query[GravityBundle]
+	info  *SystemInfo
+}
+
+func (g gravitySystem) Update() error {
+	g.query.ForEach(func(b *GravityBundle) {
+		b.Vel.X += b.Gravity.Value
+	})
+
+	return nil
+}
+
+type thinkSystem struct {
+	Query   Query[struct{ Thought *Thought }]
+	Manager *Manager
+}
+
+func (s thinkSystem) Update() error {
+	s.Query.ForEach(func(b *struct{ Thought *Thought }) {
+		b.Thought.Count++
+	})
+
+	return nil
+}
+
+func TestParallelSystems(t *testing.T) {
+	app := NewApp()
+
+	for i := 0; i < 1000; i++ {
+		app.Manager.Spawn(struct {
+			Velocity
+			Gravity
+		}{Gravity: Gravity{1}})
+		app.Manager.Spawn(struct{ Thought }{})
+	}
+
+	gravityQuery, err := NewQuery[GravityBundle](app)
+	require.NoError(t, err)
+	thinkQuery, err := NewQuery[struct{ Thought *Thought }](app)
+	require.NoError(t, err)
+
+	gravity := gravitySystem{query: gravityQuery, info: app.SystemInfo}
+	think := thinkSystem{Query: thinkQuery, Manager: app.Manager}
+	anotherThink := thinkSystem{Query: thinkQuery}
+
+	var exclusiveRuns int
+	err = app.AddConfiguredSystems(Update,
+		Configure(gravity.Update).Access(gravity),
+		Configure(think.Update).Access(think),
+		Configure(anotherThink.Update).Access(&anotherThink),
+		Configure(func() error {
+			exclusiveRuns++
+			return nil
+		}),
+		Configure(gravity.Update).Access(gravity.query).After("think"),
+		Configure(think.Update).Access(think).Label("think"),
+	)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, app.Update())
+	}
+
+	waves := app.schedules[Update].waves
+	require.Len(t, waves, 5)
+	require.Len(t, waves[0], 2)
+	for _, wave := range waves[1:] {
+		require.Len(t, wave, 1)
+	}
+
+	require.Equal(t, 10, exclusiveRuns)
+
+	velocities, err := NewQuery[struct{ Velocity }](app)
+	require.NoError(t, err)
+	velocities.ForEach(func(b *struct{ Velocity }) {
+		require.Equal(t, 20.0, b.X)
+	})
+
+	thoughts, err := NewQuery[struct{ Thought }](app)
+	require.NoError(t, err)
+	thoughts.ForEach(func(b *struct{ Thought }) {
+		require.Equal(t, 30, b.Count)
+	})
+}
+
+func TestParForEach(t *testing.T) {
+	app := NewApp()
+
+	for i := 0; i < 1000; i++ {
+		app.Manager.Spawn(SimpleX{i})
+		app.Manager.Spawn(Bundle{i, "y"})
+	}
+	require.NoError(t, app.Update())
+
+	values, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+	values.ParForEach(func(x *SimpleX) {
+		x.X *= 2
+	}, ParOptions{BatchSize: 7, Workers: 4})
+
+	pointers, err := NewQuery[struct{ X *int }](app)
+	require.NoError(t, err)
+	pointers.ParForEach(func(b *struct{ X *int }) {
+		*b.X++
+	}, ParOptions{})
+
+	changed, err := NewQuery[struct {
+		X int
+		Changed[int]
+	}](app)
+	require.NoError(t, err)
+
+	var sum, count int
+	changed.ForEach(func(b *struct {
+		X int
+		Changed[int]
+	}) {
+		sum += b.X
+		count++
+	})
+	require.Equal(t, 2000, count)
+	require.Equal(t, 2*(999*1000+1000), sum)
+}
+
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestFixedUpdate(t *testing.T) {
+	app := NewApp()
+
+	clock := &manualClock{now: time.Unix(0, 0)}
+	app.SetClock(clock)
+
+	fixed, err := Resource[FixedTime](app)
+	require.NoError(t, err)
+	fixed.Step = 10 * time.Millisecond
+	fixed.MaxSteps = 3
+
+	var order []string
+	require.NoError(t, app.AddSystemsTo(PreUpdate, func() error {
+		order = append(order, "pre")
+		return nil
+	}))
+	require.NoError(t, app.AddSystemsTo(FixedUpdate, func() error {
+		order = append(order, "fixed")
+		return nil
+	}))
+	require.NoError(t, app.AddSystems(func() error {
+		order = append(order, "update")
+		return nil
+	}))
+
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"pre", "update"}, order)
+
+	order = nil
+	clock.Advance(25 * time.Millisecond)
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"pre", "fixed", "fixed", "update"}, order)
+	require.InDelta(t, 0.5, fixed.Alpha, 1e-9)
+
+	order = nil
+	clock.Advance(4 * time.Millisecond)
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"pre", "update"}, order)
+	require.InDelta(t, 0.9, fixed.Alpha, 1e-9)
+
+	order = nil
+	clock.Advance(time.Second + 3*time.Millisecond)
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"pre", "fixed", "fixed", "fixed", "update"}, order)
+	require.InDelta(t, 0.2, fixed.Alpha, 1e-9)
+}
+
+func TestTime(t *testing.T) {
+	app := NewApp()
+
+	clock := &manualClock{now: time.Unix(0, 0)}
+	app.SetClock(clock)
+
+	appTime, err := Resource[Time](app)
+	require.NoError(t, err)
+
+	var deltas []time.Duration
+	require.NoError(t, app.AddSystems(func() error {
+		deltas = append(deltas, appTime.Delta)
+		return nil
+	}))
+
+	require.NoError(t, app.Update())
+	require.Equal(t, uint64(1), appTime.Tick)
+	require.Zero(t, appTime.Delta)
+
+	clock.Advance(20 * time.Millisecond)
+	require.NoError(t, app.Update())
+
+	appTime.Scale = 0.5
+	clock.Advance(20 * time.Millisecond)
+	require.NoError(t, app.Update())
+
+	appTime.Paused = true
+	clock.Advance(20 * time.Millisecond)
+	require.NoError(t, app.Update())
+
+	require.Equal(t, []time.Duration{0, 20 * time.Millisecond, 10 * time.Millisecond, 0}, deltas)
+	require.Equal(t, uint64(4), appTime.Tick)
+	require.Equal(t, 30*time.Millisecond, appTime.Elapsed)
+	require.Equal(t, 60*time.Millisecond, appTime.RealElapsed)
+	require.Equal(t, 20*time.Millisecond, appTime.RealDelta)
+}
+
+type GameState int
+
+const (
+	Menu GameState = iota
+	Playing
+	Paused
+)
+
+func TestStates(t *testing.T) {
+	app := NewApp()
+
+	require.NoError(t, AddState(app, Menu))
+	require.Error(t, AddState(app, Playing))
+
+	var log []string
+	record := func(entry string) System {
+		return func() error {
+			log = append(log, entry)
+			return nil
+		}
+	}
+
+	require.NoError(t, OnEnter(app, Menu, record("enter menu")))
+	require.NoError(t, OnExit(app, Menu, record("exit menu")))
+	require.NoError(t, OnEnter(app, Playing, record("enter playing")))
+	require.NoError(t, OnExit(app, Playing, record("exit playing")))
+	require.NoError(t, OnUpdate(app, Update, Playing, record("playing")))
+	require.NoError(t, OnUpdate(app, Update, Menu, record("menu")))
+	require.Error(t, OnEnter(app, "unknown"))
+
+	state, err := Resource[State[GameState]](app)
+	require.NoError(t, err)
+
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"enter menu", "menu"}, log)
+
+	log = nil
+	SetState(app.Manager, Playing)
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"menu"}, log)
+	require.Equal(t, Menu, state.Get())
+
+	log = nil
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"exit menu", "enter playing", "playing"}, log)
+	require.Equal(t, Playing, state.Get())
+
+	log = nil
+	require.NoError(t, app.AddSystemsTo(Last, func() error {
+		if state.Get() == Playing {
+			SetState(app.Manager, Paused)
+		}
+		return nil
+	}))
+	require.NoError(t, app.Update())
+	require.NoError(t, app.Update())
+	require.Equal(t, []string{"playing", "exit playing"}, log)
+	require.Equal(t, Paused, state.Get())
+
+	var skipped error
+	app.SetCommandErrorHandler(func(err error) {
+		skipped = err
+	})
+
+	SetState(app.Manager, "unknown")
+	require.NoError(t, app.Update())
+	require.ErrorContains(t, skipped, "state is not added")
+}
+
+func TestShutdowns(t *testing.T) {
+	errSystem := errors.New("system failed")
+	errTeardown := errors.New("teardown failed")
+
+	var order []string
+	shutdown := func(name string, err error) Shutdown {
+		return func() error {
+			order = append(order, name)
+			return err
+		}
+	}
+
+	app := NewApp()
+	require.NoError(t, app.AddShutdowns(shutdown("first", nil), shutdown("second", errTeardown)))
+	require.NoError(t, app.AddShutdowns(shutdown("third", nil)))
+
+	fail := false
+	require.NoError(t, app.AddSystems(func() error {
+		if fail {
+			return errSystem
+		}
+		return nil
+	}))
+
+	require.NoError(t, app.Update())
+	require.Empty(t, order)
+
+	fail = true
+	err := app.Update()
+	require.ErrorIs(t, err, errSystem)
+	require.ErrorIs(t, err, errTeardown)
+	require.Equal(t, []string{"third", "second", "first"}, order)
+
+	require.ErrorIs(t, app.Update(), ErrClosed)
+	require.NoError(t, app.Close())
+	require.Len(t, order, 3)
+
+	// failed commands fail only the tick, shutdowns run on errors of systems and on Close
+	order = nil
+	spawning := NewApp()
+	require.NoError(t, spawning.AddShutdowns(shutdown("spawning", nil)))
+	spawning.Manager.Spawn(42)
+	spawning.Manager.Spawn(SimpleX{1})
+	require.ErrorIs(t, spawning.Update(), ErrNotStruct)
+	require.Empty(t, order)
+	spawning.Manager.Spawn(SimpleX{2})
+	require.NoError(t, spawning.Update())
+	require.Equal(t, 1, spawning.SystemInfo.Entities)
+	require.NoError(t, spawning.Close())
+	require.Equal(t, []string{"spawning"}, order)
+
+	order = nil
+	closed := NewApp()
+	require.NoError(t, closed.AddShutdowns(shutdown("only", nil)))
+	require.NoError(t, closed.Close())
+	require.NoError(t, closed.Close())
+	require.Equal(t, []string{"only"}, order)
+}
+
+func TestStartupGraph(t *testing.T) {
+	app := NewApp()
+
+	var order []string
+	assets := 0
+	require.NoError(t, app.AddConfiguredStartups(
+		ConfigureStartup(func() (bool, error) {
+			order = append(order, "world")
+			return true, nil
+		}).After("assets"),
+		ConfigureStartup(func() (bool, error) {
+			order = append(order, "assets")
+			assets++
+			return assets == 4, nil
+		}).Name("assets").Progress(func() float64 {
+			return float64(assets) / 4
+		}),
+	))
+
+	require.NoError(t, app.Update())
+	progress, err := Resource[LoadingProgress](app)
+	require.NoError(t, err)
+	require.Equal(t, LoadingProgress{Progress: 0.125, Finished: 0, Total: 2}, *progress)
+
+	require.NoError(t, app.Update())
+	require.NoError(t, app.Update())
+	require.Equal(t, LoadingProgress{Progress: 0.375, Finished: 0, Total: 2}, *progress)
+
+	// world is registered before assets, so it runs on the next tick after assets finish
+	require.NoError(t, app.Update())
+	require.Equal(t, LoadingProgress{Progress: 0.5, Finished: 1, Total: 2}, *progress)
+
+	require.NoError(t, app.Update())
+	require.Equal(t, LoadingProgress{Progress: 1, Finished: 2, Total: 2}, *progress)
+	require.Equal(t, []string{"assets", "assets", "assets", "assets", "world"}, order)
+
+	cyclic := NewApp()
+	require.NoError(t, cyclic.AddConfiguredStartups(
+		ConfigureStartup(func() (bool, error) { return true, nil }).Name("a").After("b"),
+		ConfigureStartup(func() (bool, error) { return true, nil }).Name("b").After("a"),
+	))
+	require.ErrorContains(t, cyclic.Update(), "cyclic dependencies")
+
+	unknown := NewApp()
+	require.NoError(t, unknown.AddConfiguredStartups(
+		ConfigureStartup(func() (bool, error) { return true, nil }).After("missing"),
+	))
+	require.ErrorContains(t, unknown.Update(), `unknown startup "missing"`)
+
+	slow := NewApp()
+	clock := &manualClock{now: time.Unix(0, 0)}
+	slow.SetClock(clock)
+	require.NoError(t, slow.AddConfiguredStartups(
+		ConfigureStartup(func() (bool, error) { return false, nil }).Name("slow").Timeout(time.Second),
+	))
+
+	require.NoError(t, slow.Update())
+	clock.Advance(500 * time.Millisecond)
+	require.NoError(t, slow.Update())
+	clock.Advance(500 * time.Millisecond)
+	require.ErrorIs(t, slow.Update(), ErrStartupTimeout)
+}
+
+type Target struct {
+	ID EntityID
+}
+
+type Missile struct {
+	Target   Target
+	Velocity *Velocity
+}
+
+type GravityBundleValue struct {
+	Vel     Velocity
+	Gravity Gravity
+}
+
+func TestGet(t *testing.T) {
+	app := NewApp()
+
+	app.Manager.Spawn(struct {
+		Velocity Velocity
+		Gravity  Gravity
+	}{Velocity{1}, Gravity{2}})
+	app.Manager.Spawn(struct{ Velocity Velocity }{Velocity{3}})
+	require.NoError(t, app.flush())
+
+	var full, partial EntityID
+	query, err := NewQuery[struct {
+		Velocity Velocity
+		Gravity  Optional[Gravity]
+	}](app)
+	require.NoError(t, err)
+	query.Iterate(func(id EntityID, b *struct {
+		Velocity Velocity
+		Gravity  Optional[Gravity]
+	}) bool {
+		if _, ok := b.Gravity.Get(); ok {
+			full = id
+		} else {
+			partial = id
+		}
+		return true
+	})
+
+	velocity, ok := Get[Velocity](app, partial)
+	require.True(t, ok)
+	require.Equal(t, 3.0, velocity.X)
+	velocity.X = 4
+
+	_, ok = Get[Gravity](app, partial)
+	require.False(t, ok)
+	_, ok = Get[Score](app, partial)
+	require.False(t, ok)
+
+	// structs which are not components of the entity are looked up as bundles
+	bundle, ok := Get[GravityBundle](app, full)
+	require.True(t, ok)
+	require.Equal(t, 1.0, bundle.Vel.X)
+	require.Equal(t, 2.0, bundle.Gravity.Value)
+	_, ok = Get[GravityBundle](app, partial)
+	require.False(t, ok)
+
+	// the lookup depends only on the components of the entity
+	app.Manager.Spawn(struct{ Bundle GravityBundleValue }{})
+	require.NoError(t, app.flush())
+	copied, ok := Get[GravityBundleValue](app, full)
+	require.True(t, ok)
+	require.Equal(t, GravityBundleValue{Velocity{1}, Gravity{2}}, *copied)
+
+	require.Panics(t, func() {
+		Get[struct {
+			A Velocity
+			B *Velocity
+		}](app, full)
+	})
+
+	gravities, err := NewQuery[GravityBundle](app)
+	require.NoError(t, err)
+	_, ok = gravities.Get(partial)
+	require.False(t, ok)
+	bundle, ok = gravities.Get(full)
+	require.True(t, ok)
+	bundle.Gravity.Value = 5
+
+	// a missile looks up the velocity of its target
+	app.Manager.Spawn(struct {
+		Target   Target
+		Velocity Velocity
+	}{Target: Target{partial}})
+	require.NoError(t, app.flush())
+
+	missiles, err := NewQuery[Missile](app)
+	require.NoError(t, err)
+	missiles.ForEach(func(m *Missile) {
+		target, ok := Get[Velocity](app, m.Target.ID)
+		require.True(t, ok)
+		m.Velocity.X = target.X
+	})
+
+	velocities, err := NewQuery[struct {
+		Velocity Velocity
+		Without  Without[Gravity]
+		Target   Optional[Target]
+	}](app)
+	require.NoError(t, err)
+
+	var values []float64
+	velocities.ForEach(func(b *struct {
+		Velocity Velocity
+		Without  Without[Gravity]
+		Target   Optional[Target]
+	}) {
+		values = append(values, b.Velocity.X)
+	})
+	require.ElementsMatch(t, []float64{4, 4}, values)
+
+	gravity, ok := Get[Gravity](app, full)
+	require.True(t, ok)
+	require.Equal(t, 5.0, gravity.Value)
+
+	app.Manager.Despawn(full)
+	require.NoError(t, app.flush())
+	_, ok = Get[Gravity](app, full)
+	require.False(t, ok)
+}
+
+type Parent struct {
+	ID EntityID
+}
+
+func TestSpawnReservesID(t *testing.T) {
+	app := NewApp()
+
+	parent := app.Manager.Spawn(SimpleX{1})
+	child := app.Manager.Spawn(struct{ Parent Parent }{Parent{parent}})
+
+	// commands queued in the same tick may target the reserved IDs
+	app.Manager.Insert(child, Score{10})
+	Remove[int](app.Manager, parent)
+	app.Manager.Insert(parent, Velocity{2})
+
+	require.NotEqual(t, parent, child)
+	require.False(t, app.Alive(parent))
+	require.False(t, app.Alive(child))
+
+	require.NoError(t, app.flush())
+	require.True(t, app.Alive(parent))
+	require.True(t, app.Alive(child))
+
+	link, ok := Get[Parent](app, child)
+	require.True(t, ok)
+	require.Equal(t, parent, link.ID)
+
+	score, ok := Get[Score](app, child)
+	require.True(t, ok)
+	require.Equal(t, 10, score.Value)
+
+	_, ok = Get[int](app, parent)
+	require.False(t, ok)
+	velocity, ok := Get[Velocity](app, parent)
+	require.True(t, ok)
+	require.Equal(t, 2.0, velocity.X)
+
+	// IDs reserved by discarded spawns are released
+	app.Manager.Spawn(42)
+	discarded := app.Manager.Spawn(SimpleX{4})
+	require.Error(t, app.flush())
+	require.False(t, app.Alive(discarded))
+
+	reused := app.Manager.Spawn(SimpleX{5})
+	require.Equal(t, discarded.Index(), reused.Index())
+	require.NotEqual(t, discarded.Generation(), reused.Generation())
+}
+
+func TestSpawnErrors(t *testing.T) {
+	app := NewApp()
+
+	query, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+
+	count := func() int {
+		var n int
+		query.ForEach(func(*SimpleX) {
+			n++
+		})
+		return n
+	}
+
+	app.Manager.Spawn(SimpleX{1})
+	bad := app.Manager.Spawn(42)
+	app.Manager.Spawn(SimpleX{2})
+
+	err = app.flush()
+	require.ErrorIs(t, err, ErrNotStruct)
+
+	var spawnErr *SpawnError
+	require.ErrorAs(t, err, &spawnErr)
+	require.Equal(t, bad, spawnErr.Entity)
+	require.Equal(t, reflect.TypeOf(42), spawnErr.Bundle)
+	require.Empty(t, app.Manager.queue)
+	require.Equal(t, 1, count())
+
+	app.Manager.Spawn(struct {
+		A int
+		B int
+	}{})
+	require.ErrorIs(t, app.flush(), ErrDuplicateComponent)
+	require.Empty(t, app.Manager.queue)
+
+	nilBundle := app.Manager.Spawn(nil)
+	err = app.flush()
+	require.ErrorIs(t, err, ErrNotStruct)
+	require.ErrorAs(t, err, &spawnErr)
+	require.Nil(t, spawnErr.Bundle)
+	require.EqualError(t, err, fmt.Sprintf("spawn entity %s with nil bundle: bundle type should be a struct, got nil", nilBundle))
+
+	app.SetSpawnPolicy(SkipSpawnErrors)
+
+	skipped := app.Manager.Spawn("bunny")
+	app.Manager.Insert(skipped, Score{1})
+	app.Manager.Spawn(SimpleX{3})
+	require.NoError(t, app.flush())
+	require.Empty(t, app.Manager.queue)
+	require.False(t, app.Alive(skipped))
+	require.Equal(t, 2, count())
+}
+
+type Label struct {
+	Name *string
+	Tags []string
+}
+
+func TestPointerComponentsSurviveGC(t *testing.T) {
+	app := NewApp()
+
+	for i := 0; i < 100; i++ {
+		app.Manager.Spawn(struct{ Label Label }{})
+	}
+	require.NoError(t, app.flush())
+
+	query, err := NewQuery[struct{ Label Label }](app)
+	require.NoError(t, err)
+
+	// value fields are written back to the columns while the collector may run concurrently
+	i := 0
+	query.ForEach(func(b *struct{ Label Label }) {
+		name := fmt.Sprintf("label %d", i)
+		b.Label.Name = &name
+		b.Label.Tags = []string{name}
+		i++
+	})
+
+	runtime.GC()
+	runtime.GC()
+
+	var names []string
+	query.ForEach(func(b *struct{ Label Label }) {
+		require.Equal(t, *b.Label.Name, b.Label.Tags[0])
+		names = append(names, *b.Label.Name)
+	})
+	require.Len(t, names, 100)
+	require.Contains(t, names, "label 99")
+}
+
+type spawnSystem struct {
+	Manager *Manager
+	Value   int
+}
+
+func (s spawnSystem) Update() error {
+	for i := 0; i < 100; i++ {
+		s.Manager.Spawn(SimpleX{s.Value})
+	}
+
+	return nil
+}
+
+func TestParallelCommandsOrder(t *testing.T) {
+	app := NewApp()
+
+	first := spawnSystem{Manager: app.Manager, Value: 1}
+	second := spawnSystem{Manager: app.Manager, Value: 2}
+	require.NoError(t, app.AddConfiguredSystems(Update,
+		Configure(first.Update).Access(first),
+		Configure(second.Update).Access(second),
+	))
+	require.NoError(t, app.Update())
+
+	// systems queueing commands run one by one, so commands and IDs follow the schedule order
+	require.Len(t, app.schedules[Update].waves, 2)
+
+	query, err := NewQuery[SimpleX](app)
+	require.NoError(t, err)
+	query.Iterate(func(id EntityID, x *SimpleX) bool {
+		require.Equal(t, int(id.Index())/100+1, x.X)
+		return true
+	})
+}
+
+func TestAccessHolders(t *testing.T) {
+	app := NewApp()
+
+	gravities, err := NewQuery[GravityBundle](app)
+	require.NoError(t, err)
+	velocities, err := NewQuery[struct{ Vel *Velocity }](app)
+	require.NoError(t, err)
+
+	waves := func(holders ...any) int {
+		app.schedules[Update] = schedule{stage: Update}
+		require.NoError(t, app.AddConfiguredSystems(Update,
+			Configure(func() error { return nil }).Access(gravities),
+			Configure(func() error { return nil }).Access(holders...),
+		))
+		require.NoError(t, app.schedules[Update].sort(app))
+		return len(app.schedules[Update].waves)
+	}
+
+	require.Equal(t, 2, waves(struct {
+		Qs [1]Query[struct{ Vel *Velocity }]
+	}{[1]Query[struct{ Vel *Velocity }]{velocities}}))
+	require.Equal(t, 2, waves(struct {
+		Qs []Query[struct{ Vel *Velocity }]
+	}{[]Query[struct{ Vel *Velocity }]{velocities}}))
+	require.Equal(t, 2, waves(struct{ Q any }{velocities}))
+	require.Equal(t, 2, waves(map[string]any{"velocities": velocities}))
+
+	// access which can't be derived or is empty makes the system exclusive
+	require.Equal(t, 2, waves(struct{ Update func() }{func() {}}))
+	require.Equal(t, 2, waves(struct{ Values []float64 }{make([]float64, 10)}))
+
+	thoughts, err := NewQuery[struct{ Thought *Thought }](app)
+	require.NoError(t, err)
+	require.Equal(t, 1, waves(struct{ Q any }{thoughts}))
+}
+
+type Flag struct {
+	On bool
+}
+
+func TestConditionsInWaves(t *testing.T) {
+	app := NewApp()
+	InsertResource(app, Flag{})
+	flag, err := Resource[Flag](app)
+	require.NoError(t, err)
+
+	gravities, err := NewQuery[GravityBundle](app)
+	require.NoError(t, err)
+	thoughts, err := NewQuery[struct{ Thought *Thought }](app)
+	require.NoError(t, err)
+	velocities, err := NewQuery[struct{ Vel *Velocity }](app)
+	require.NoError(t, err)
+
+	var a, b, c int
+	require.NoError(t, app.AddConfiguredSystems(Update,
+		Configure(func() error {
+			flag.On = true
+			a++
+			return nil
+		}).Access(gravities, flag),
+		Configure(func() error {
+			b++
+			return nil
+		}).Access(thoughts).RunIf(func() bool { return flag.On }),
+		Configure(func() error {
+			flag.On = false
+			c++
+			return nil
+		}).Access(velocities, flag),
+	))
+
+	// b sees the flag set by a, while c runs together with b only after b's condition is evaluated
+	for i := 0; i < 3; i++ {
+		require.NoError(t, app.Update())
+	}
+	require.Equal(t, []int{3, 3, 3}, []int{a, b, c})
+	require.False(t, flag.On)
+	require.Len(t, app.schedules[Update].waves, 2)
+}
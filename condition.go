@@ -0,0 +1,111 @@
+package herd
+
+import (
+	"reflect"
+	"time"
+)
+
+// Condition decides whether a system should run this time
+type Condition func() bool
+
+// condition evaluates a Condition at most once per stage run, so systems of a group share the result
+type condition struct {
+	check  Condition
+	pass   uint64
+	result bool
+}
+
+func (c *condition) evaluate(pass uint64) bool {
+	if c.pass != pass {
+		c.pass = pass
+		c.result = c.check()
+	}
+
+	return c.result
+}
+
+func newConditions(checks []Condition) []*condition {
+	conditions := make([]*condition, len(checks))
+	for i, check := range checks {
+		conditions[i] = &condition{check: check}
+	}
+
+	return conditions
+}
+
+// SystemGroup is a set of systems that share run conditions
+type SystemGroup []*SystemConfig
+
+// Group returns a group of the systems
+func Group(configs ...*SystemConfig) SystemGroup {
+	return configs
+}
+
+// RunIf adds conditions to every system of the group. Conditions are evaluated once per stage run for the whole group,
+// and systems of the group run only if all of them are true
+func (g SystemGroup) RunIf(conditions ...Condition) SystemGroup {
+	shared := newConditions(conditions)
+	for _, config := range g {
+		config.conditions = append(config.conditions, shared...)
+	}
+
+	return g
+}
+
+// EveryNTicks is true once in n ticks of the App, see Time.Tick. It is never true if n is zero
+func EveryNTicks(app *App, n uint64) Condition {
+	if n == 0 {
+		return func() bool {
+			return false
+		}
+	}
+
+	return func() bool {
+		return app.time.Tick%n == 0
+	}
+}
+
+// Every is true when at least d of real time passed since it was true last time. Condition is stateful,
+// so use a separate one for every system or attach it to a SystemGroup
+func Every(app *App, d time.Duration) Condition {
+	var last time.Time
+
+	return func() bool {
+		now := app.clock.Now()
+		if now.Sub(last) < d {
+			return false
+		}
+
+		last = now
+
+		return true
+	}
+}
+
+// ResourceExists is true if the App has a resource of type T
+func ResourceExists[T any](app *App) Condition {
+	return func() bool {
+		return HasResource[T](app)
+	}
+}
+
+// ResourceChanged is true if the resource of type T was inserted or its value differs from the value at the previous check.
+// Resource is compared with its shallow copy, so changes behind pointers, slices and maps of the resource are not detected
+func ResourceChanged[T any](app *App) Condition {
+	var previous *T
+
+	return func() bool {
+		res, err := Resource[T](app)
+		if err != nil {
+			previous = nil
+			return false
+		}
+
+		changed := previous == nil || !reflect.DeepEqual(*previous, *res)
+
+		value := *res
+		previous = &value
+
+		return changed
+	}
+}
@@ -0,0 +1,101 @@
+// Package ebitengine runs herd App with Ebitengine
+package ebitengine
+
+import (
+	"errors"
+	"image"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"github.com/elemir/herd"
+)
+
+// Renderer is a system that draws something every frame
+type Renderer func(*ebiten.Image)
+
+// App wraps herd.App with renderers and implements ebiten.Game interface
+type App struct {
+	*herd.App
+
+	renderers []Renderer
+
+	// err keeps errors of shutdowns which ran because a system returned ebiten.Termination
+	err error
+}
+
+// NewApp returns a new herd.App wrapped for Ebitengine
+func NewApp() *App {
+	return Wrap(herd.NewApp())
+}
+
+// Wrap returns App which updates app every tick and draws its renderers every frame. Existing code keeps building
+// *herd.App and wraps it only where renderers are added and the game is run
+func Wrap(app *herd.App) *App {
+	return &App{
+		App: app,
+	}
+}
+
+func (app *App) AddRenderers(renderers ...Renderer) error {
+	app.renderers = append(app.renderers, renderers...)
+
+	return nil
+}
+
+func (app *App) Draw(screen *ebiten.Image) {
+	for _, renderer := range app.renderers {
+		renderer(screen)
+	}
+}
+
+// Layout keeps the screen size equal to the window size and stores it in SystemInfo.Bounds
+func (app *App) Layout(w, h int) (int, int) {
+	app.SystemInfo.Bounds = image.Rect(0, 0, w, h)
+
+	return w, h
+}
+
+// Update runs a single tick of App. Ebitengine stops the game without an error only if Update returns
+// ebiten.Termination itself, so errors of shutdowns joined with Termination are kept and returned by Close
+func (app *App) Update() error {
+	err := app.App.Update()
+	if !errors.Is(err, ebiten.Termination) {
+		return err
+	}
+
+	app.err = withoutTermination(err)
+
+	return ebiten.Termination
+}
+
+// Close runs shutdowns unless they already ran and returns errors of shutdowns kept by Update
+func (app *App) Close() error {
+	err := errors.Join(app.err, app.App.Close())
+	app.err = nil
+
+	return err
+}
+
+// Run runs the game loop until the window is closed or a system returns an error, then closes App
+func (app *App) Run() error {
+	return errors.Join(ebiten.RunGame(app), app.Close())
+}
+
+// withoutTermination removes ebiten.Termination from the errors joined by herd.App.Update
+func withoutTermination(err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		if errors.Is(err, ebiten.Termination) {
+			return nil
+		}
+
+		return err
+	}
+
+	var kept []error
+	for _, err := range joined.Unwrap() {
+		kept = append(kept, withoutTermination(err))
+	}
+
+	return errors.Join(kept...)
+}
@@ -0,0 +1,50 @@
+package ebitengine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTermination(t *testing.T) {
+	errTeardown := errors.New("teardown failed")
+
+	app := NewApp()
+	require.NoError(t, app.AddSystems(func() error {
+		return ebiten.Termination
+	}))
+	require.NoError(t, app.AddShutdowns(func() error {
+		return errTeardown
+	}))
+
+	// Ebitengine gets plain Termination, while errors of shutdowns are returned by Close
+	require.Equal(t, ebiten.Termination, app.Update())
+
+	err := app.Close()
+	require.ErrorIs(t, err, errTeardown)
+	require.NotErrorIs(t, err, ebiten.Termination)
+	require.NoError(t, app.Close())
+
+	errSystem := errors.New("system failed")
+	failing := NewApp()
+	require.NoError(t, failing.AddSystems(func() error {
+		return errSystem
+	}))
+	require.NoError(t, failing.AddShutdowns(func() error {
+		return errTeardown
+	}))
+
+	err = failing.Update()
+	require.ErrorIs(t, err, errSystem)
+	require.ErrorIs(t, err, errTeardown)
+	require.NoError(t, failing.Close())
+
+	clean := NewApp()
+	require.NoError(t, clean.AddSystems(func() error {
+		return ebiten.Termination
+	}))
+	require.Equal(t, ebiten.Termination, clean.Update())
+	require.NoError(t, clean.Close())
+}
@@ -0,0 +1,80 @@
+package herd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EntityID identifies an entity. The lower 32 bits hold an index that is reused after the entity is despawned
+// and the upper 32 bits hold a generation of that index, so a stale ID never refers to a newer entity.
+// Zero EntityID never refers to an alive entity
+type EntityID uint64
+
+func newEntityID(index, generation uint32) EntityID {
+	return EntityID(generation)<<32 | EntityID(index)
+}
+
+// Index returns the index part of the ID
+func (id EntityID) Index() uint32 {
+	return uint32(id)
+}
+
+// Generation returns the generation part of the ID
+func (id EntityID) Generation() uint32 {
+	return uint32(id >> 32)
+}
+
+func (id EntityID) String() string {
+	return fmt.Sprintf("%dv%d", id.Index(), id.Generation())
+}
+
+// entities allocates entity IDs. IDs are reserved by Manager.Spawn while systems run, so entities is safe for concurrent use
+type entities struct {
+	mu sync.Mutex
+
+	generations []uint32
+	alive       []bool
+	free        []uint32
+}
+
+func (e *entities) allocate() EntityID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if n := len(e.free); n > 0 {
+		index := e.free[n-1]
+		e.free = e.free[:n-1]
+		e.alive[index] = true
+
+		return newEntityID(index, e.generations[index])
+	}
+
+	index := uint32(len(e.generations))
+	e.generations = append(e.generations, 1)
+	e.alive = append(e.alive, true)
+
+	return newEntityID(index, 1)
+}
+
+func (e *entities) release(id EntityID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	index := id.Index()
+
+	e.alive[index] = false
+	e.generations[index]++
+	if e.generations[index] == 0 {
+		e.generations[index] = 1
+	}
+	e.free = append(e.free, index)
+}
+
+func (e *entities) contains(id EntityID) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	index := id.Index()
+
+	return int(index) < len(e.generations) && e.alive[index] && e.generations[index] == id.Generation()
+}
@@ -0,0 +1,80 @@
+package herd
+
+import (
+	"fmt"
+)
+
+// Events is a double-buffered queue of events of type T. Events are kept for two ticks, so every reader
+// that runs once per tick sees every event regardless of the order of the sender and the reader
+type Events[T any] struct {
+	previous []T
+	current  []T
+
+	// start is a sequence number of the first event in previous
+	start int
+}
+
+// Send adds the event to the queue
+func (e *Events[T]) Send(event T) {
+	e.current = append(e.current, event)
+}
+
+func (e *Events[T]) update() {
+	e.start += len(e.previous)
+	e.previous, e.current = e.current, e.previous[:0]
+}
+
+func (e *Events[T]) end() int {
+	return e.start + len(e.previous) + len(e.current)
+}
+
+// AddEvent registers events of type T. Events are available as Events[T] resource and are swapped at the start of each App.Update
+func AddEvent[T any](app *App) {
+	if HasResource[Events[T]](app) {
+		return
+	}
+
+	InsertResource(app, Events[T]{})
+	events, _ := Resource[Events[T]](app)
+	app.events = append(app.events, events)
+}
+
+// EventReader reads events of type T, so each event is seen by the reader once
+type EventReader[T any] struct {
+	events *Events[T]
+	next   int
+}
+
+// NewEventReader returns a reader of events of type T, registered with AddEvent. The reader sees only events sent after its creation
+func NewEventReader[T any](app *App) (*EventReader[T], error) {
+	events, err := Resource[Events[T]](app)
+	if err != nil {
+		return nil, fmt.Errorf("create event reader: %w", err)
+	}
+
+	return &EventReader[T]{
+		events: events,
+		next:   events.end(),
+	}, nil
+}
+
+// ForEach calls f for every event the reader hasn't seen yet. Events older than two ticks are missed
+func (r *EventReader[T]) ForEach(f func(event *T)) {
+	if r.next < r.events.start {
+		r.next = r.events.start
+	}
+
+	for i := r.next - r.events.start; i < len(r.events.previous); i++ {
+		f(&r.events.previous[i])
+	}
+
+	from := r.next - r.events.start - len(r.events.previous)
+	if from < 0 {
+		from = 0
+	}
+	for i := from; i < len(r.events.current); i++ {
+		f(&r.events.current[i])
+	}
+
+	r.next = r.events.end()
+}
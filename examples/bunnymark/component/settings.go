@@ -1,15 +1,12 @@
 package component
 
 import (
-	"time"
-
 	"github.com/hajimehoshi/ebiten/v2"
 
 	"github.com/elemir/herd/examples/bunnymark/helper"
 )
 
 type Settings struct {
-	Ticker   *time.Ticker
 	Sprite   *ebiten.Image
 	Colorful bool
 	Amount   int
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"math/rand"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/elemir/herd"
+	"github.com/elemir/herd/ebitengine"
 	"github.com/hajimehoshi/ebiten/v2"
 
 	"github.com/elemir/herd/examples/bunnymark/assets"
@@ -19,11 +21,10 @@ import (
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
-func CreateApp() (*herd.App, error) {
+func CreateApp() (*ebitengine.App, error) {
 	app := herd.NewApp()
 
-	settings := component.Settings{
-		Ticker:   time.NewTicker(500 * time.Millisecond),
+	herd.InsertResource(app, component.Settings{
 		Gpu:      helper.GpuInfo(),
 		Tps:      helper.NewPlot(20, 60),
 		Fps:      helper.NewPlot(20, 60),
@@ -31,7 +32,7 @@ func CreateApp() (*herd.App, error) {
 		Sprite:   assets.Bunny,
 		Colorful: false,
 		Amount:   1000,
-	}
+	})
 
 	velocity, err := system.NewVelocity(app)
 	if err != nil {
@@ -48,12 +49,12 @@ func CreateApp() (*herd.App, error) {
 		return nil, err
 	}
 
-	metrics, err := system.NewMetrics(app, &settings)
+	metrics, err := system.NewMetrics(app)
 	if err != nil {
 		return nil, err
 	}
 
-	spawn, err := system.NewSpawn(app, &settings)
+	spawn, err := system.NewSpawn(app)
 	if err != nil {
 		return nil, err
 	}
@@ -63,18 +64,30 @@ func CreateApp() (*herd.App, error) {
 		return nil, err
 	}
 
-	if err := app.AddSystems(
-		velocity.Update, gravity.Update, bounce.Update,
-		bounce.Update, metrics.Update, spawn.Update,
+	if err := app.AddSystemsTo(herd.PreUpdate, spawn.Update); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := app.AddConfiguredSystems(herd.FixedUpdate,
+		herd.Configure(velocity.Update).Label("velocity").Access(velocity),
+		herd.Configure(gravity.Update).Label("gravity").After("velocity").Access(gravity),
+		herd.Configure(bounce.Update).Label("bounce").After("gravity").Access(bounce),
+	); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := app.AddConfiguredSystems(herd.Last,
+		herd.Configure(metrics.Update).RunIf(herd.Every(app, 500*time.Millisecond)),
 	); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := app.AddRenderers(system.Background, render.Draw, metrics.Draw); err != nil {
+	game := ebitengine.Wrap(app)
+	if err := game.AddRenderers(system.Background, render.Draw, metrics.Draw); err != nil {
 		log.Fatal(err)
 	}
 
-	return app, nil
+	return game, nil
 }
 
 func main() {
@@ -99,7 +112,8 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if err := ebiten.RunGame(app); err != nil {
+	// shutdowns already ran if a system failed, otherwise the window was closed and they run here
+	if err := errors.Join(ebiten.RunGame(app), app.Close()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -8,9 +8,9 @@ import (
 )
 
 type Texture struct {
-	Pos    component.Position
-	Vel    component.Velocity
-	Sprite component.Sprite
+	Pos    *component.Position
+	Vel    *component.Velocity
+	Sprite *component.Sprite
 }
 
 type Bounce struct {
@@ -7,8 +7,8 @@ import (
 )
 
 type GravityBundle struct {
-	Vel     component.Velocity
-	Gravity component.Gravity
+	Vel     *component.Velocity
+	Gravity *component.Gravity
 }
 
 type Gravity struct {
@@ -27,9 +27,9 @@ func NewGravity(app *herd.App) (Gravity, error) {
 }
 
 func (g Gravity) Update() error {
-	g.Query.ForEach(func(gb *GravityBundle) {
+	g.Query.ParForEach(func(gb *GravityBundle) {
 		gb.Vel.Y += gb.Gravity.Value
-	})
+	}, herd.ParOptions{})
 
 	return nil
 }
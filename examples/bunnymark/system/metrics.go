@@ -19,7 +19,12 @@ type Metrics struct {
 	System   *herd.SystemInfo
 }
 
-func NewMetrics(app *herd.App, settings *component.Settings) (Metrics, error) {
+func NewMetrics(app *herd.App) (Metrics, error) {
+	settings, err := herd.Resource[component.Settings](app)
+	if err != nil {
+		return Metrics{}, err
+	}
+
 	return Metrics{
 		Settings: settings,
 		System:   app.SystemInfo,
@@ -27,13 +32,9 @@ func NewMetrics(app *herd.App, settings *component.Settings) (Metrics, error) {
 }
 
 func (m Metrics) Update() error {
-	select {
-	case <-m.Settings.Ticker.C:
-		m.Settings.Objects.Update(float64(m.System.Entities))
-		m.Settings.Tps.Update(ebiten.CurrentTPS())
-		m.Settings.Fps.Update(ebiten.CurrentFPS())
-	default:
-	}
+	m.Settings.Objects.Update(float64(m.System.Entities))
+	m.Settings.Tps.Update(ebiten.CurrentTPS())
+	m.Settings.Fps.Update(ebiten.CurrentFPS())
 
 	return nil
 }
@@ -8,9 +8,9 @@ import (
 )
 
 type Tile struct {
-	Pos    component.Position
-	Hue    component.Hue
-	Sprite component.Sprite
+	Pos    *component.Position
+	Hue    *component.Hue
+	Sprite *component.Sprite
 }
 
 type Render struct {
@@ -17,7 +17,12 @@ type Spawn struct {
 	System   *herd.SystemInfo
 }
 
-func NewSpawn(app *herd.App, settings *component.Settings) (Spawn, error) {
+func NewSpawn(app *herd.App) (Spawn, error) {
+	settings, err := herd.Resource[component.Settings](app)
+	if err != nil {
+		return Spawn{}, err
+	}
+
 	return Spawn{
 		Manager:  app.Manager,
 		Settings: settings,
@@ -6,8 +6,8 @@ import (
 )
 
 type MoveBundle struct {
-	Pos component.Position
-	Vel component.Velocity
+	Pos *component.Position
+	Vel *component.Velocity
 }
 
 type Velocity struct {
@@ -26,10 +26,10 @@ func NewVelocity(app *herd.App) (Velocity, error) {
 }
 
 func (v Velocity) Update() error {
-	v.Query.ForEach(func(mv *MoveBundle) {
+	v.Query.ParForEach(func(mv *MoveBundle) {
 		mv.Pos.X += mv.Vel.X
 		mv.Pos.Y += mv.Vel.Y
-	})
+	}, herd.ParOptions{})
 
 	return nil
 }
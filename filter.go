@@ -0,0 +1,119 @@
+package herd
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/elemir/herd/internal"
+)
+
+// With is a query field that requires the entity to have component T without accessing it
+type With[T any] struct{}
+
+// Without is a query field that excludes entities having component T
+type Without[T any] struct{}
+
+// Optional is a query field that points to component T if the entity has it
+type Optional[T any] struct {
+	ptr *T
+}
+
+// Get returns a pointer to the component and reports whether the entity has it
+func (o Optional[T]) Get() (*T, bool) {
+	return o.ptr, o.ptr != nil
+}
+
+// Added is a query field that requires component T to be added to the entity since the previous iteration of the query
+type Added[T any] struct{}
+
+// Changed is a query field that requires component T to be added or changed since the previous iteration of the query.
+// A component is changed when it is accessed with a pointer or Optional field, or when a copy of it is modified
+// by another query, or when it is replaced with Manager.Insert
+type Changed[T any] struct{}
+
+type filter interface {
+	access() internal.Access
+	component() reflect.Type
+}
+
+var filterType = internal.TypeOf[filter]()
+
+func (With[T]) access() internal.Access {
+	return internal.With
+}
+
+func (With[T]) component() reflect.Type {
+	return internal.TypeOf[T]()
+}
+
+func (Without[T]) access() internal.Access {
+	return internal.Without
+}
+
+func (Without[T]) component() reflect.Type {
+	return internal.TypeOf[T]()
+}
+
+func (Optional[T]) access() internal.Access {
+	return internal.Optional
+}
+
+func (Optional[T]) component() reflect.Type {
+	return internal.TypeOf[T]()
+}
+
+func (Added[T]) access() internal.Access {
+	return internal.Added
+}
+
+func (Added[T]) component() reflect.Type {
+	return internal.TypeOf[T]()
+}
+
+func (Changed[T]) access() internal.Access {
+	return internal.Changed
+}
+
+func (Changed[T]) component() reflect.Type {
+	return internal.TypeOf[T]()
+}
+
+// queryTerms describes how each field of the query bundle accesses components: filters are recognized by their type,
+// pointer fields refer directly to the components they point to and other fields are copies of components
+func queryTerms(typ reflect.Type) ([]internal.Term, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	terms := make([]internal.Term, typ.NumField())
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		term := internal.Term{
+			Field: field,
+			Component: internal.ComponentType{
+				Name: field.Tag.Get("herd"),
+				Type: field.Type,
+			},
+			Access: internal.Copy,
+		}
+
+		switch {
+		// pointers to filters have the methods of filters too, but there is no component to point to
+		case field.Type.Kind() == reflect.Pointer && field.Type.Elem().Implements(filterType):
+			return nil, fmt.Errorf("field %s of %s has type %s, filters should be used by value", field.Name, typ,
+				field.Type)
+		case field.Type.Implements(filterType):
+			f := reflect.Zero(field.Type).Interface().(filter)
+			term.Component.Type = f.component()
+			term.Access = f.access()
+		case field.Type.Kind() == reflect.Pointer:
+			term.Component.Type = field.Type.Elem()
+			term.Access = internal.Direct
+		}
+
+		terms[i] = term
+	}
+
+	return terms, nil
+}
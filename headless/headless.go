@@ -0,0 +1,51 @@
+// Package headless runs herd App without display and GPU, e.g. on a dedicated server or in CI
+package headless
+
+import (
+	"errors"
+	"image"
+	"time"
+
+	"github.com/elemir/herd"
+)
+
+// Config configures Run. Zero values mean no limits
+type Config struct {
+	// Bounds is stored in SystemInfo.Bounds before the first tick
+	Bounds image.Rectangle
+	// Ticks is a maximum number of ticks
+	Ticks int
+	// Until is checked after every tick and stops Run when it returns true
+	Until func() bool
+	// TPS limits number of ticks per second, ticks run as fast as possible if it is zero
+	TPS int
+}
+
+// Run updates app until the number of ticks is reached, Until condition is met or a system returns an error.
+// App is closed after that, so shutdowns run exactly once
+func Run(app *herd.App, config Config) error {
+	app.SystemInfo.Bounds = config.Bounds
+
+	var ticker *time.Ticker
+	if config.TPS > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(config.TPS))
+		defer ticker.Stop()
+	}
+
+	for tick := 0; config.Ticks <= 0 || tick < config.Ticks; tick++ {
+		if err := app.Update(); err != nil {
+			// App stays open if only the tick failed, so shutdowns still have to run
+			return errors.Join(err, app.Close())
+		}
+
+		if config.Until != nil && config.Until() {
+			break
+		}
+
+		if ticker != nil {
+			<-ticker.C
+		}
+	}
+
+	return app.Close()
+}
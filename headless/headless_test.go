@@ -0,0 +1,55 @@
+package headless
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elemir/herd"
+)
+
+func TestRun(t *testing.T) {
+	app := herd.NewApp()
+
+	var ticks int
+	var bounds image.Rectangle
+	require.NoError(t, app.AddSystems(func() error {
+		ticks++
+		bounds = app.SystemInfo.Bounds
+		return nil
+	}))
+
+	closed := 0
+	require.NoError(t, app.AddShutdowns(func() error {
+		closed++
+		return nil
+	}))
+
+	require.NoError(t, Run(app, Config{
+		Bounds: image.Rect(0, 0, 640, 480),
+		Ticks:  5,
+	}))
+	require.Equal(t, 5, ticks)
+	require.Equal(t, image.Rect(0, 0, 640, 480), bounds)
+	require.Equal(t, 1, closed)
+
+	until := herd.NewApp()
+	ticks = 0
+	require.NoError(t, until.AddSystems(func() error {
+		ticks++
+		return nil
+	}))
+	require.NoError(t, Run(until, Config{
+		Until: func() bool { return ticks == 3 },
+	}))
+	require.Equal(t, 3, ticks)
+
+	failing := herd.NewApp()
+	errFailed := errors.New("failed")
+	require.NoError(t, failing.AddSystems(func() error {
+		return errFailed
+	}))
+	require.ErrorIs(t, Run(failing, Config{}), errFailed)
+}
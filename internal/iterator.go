@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// Access describes how an iterator uses the component of a query term
+type Access int
+
+const (
+	// Copy copies the component into the field before the callback and back after it
+	Copy Access = iota
+	// Direct sets the pointer field to the component in the storage
+	Direct
+	// Optional works like Direct, but doesn't require the component and sets nil if the entity has no such component
+	Optional
+	// With requires the component without accessing it
+	With
+	// Without excludes entities that have the component
+	Without
+	// Added requires the component that was added since the previous iteration
+	Added
+	// Changed requires the component that was added or changed since the previous iteration
+	Changed
+)
+
+// Term describes a single field of a query bundle
+type Term struct {
+	Field     reflect.StructField
+	Component ComponentType
+	Access    Access
+}
+
+func (s *Storage[ID]) Iterator(typ reflect.Type, terms []Term) (*Iterator[ID], error) {
+	return s.iterator(typ, terms, s.componentID)
+}
+
+// Lookup returns an iterator like Iterator does, but doesn't register unknown components, so it may be created while
+// systems run concurrently. It also reports whether all components of the terms are known, otherwise the iterator
+// misses components registered later and should be used only once
+func (s *Storage[ID]) Lookup(typ reflect.Type, terms []Term) (*Iterator[ID], bool, error) {
+	complete := true
+
+	iterator, err := s.iterator(typ, terms, func(component ComponentType) int {
+		if id, ok := s.componentIDs[component]; ok {
+			return id
+		}
+
+		complete = false
+
+		return -1
+	})
+
+	return iterator, complete, err
+}
+
+func (s *Storage[ID]) iterator(typ reflect.Type, terms []Term, componentID func(ComponentType) int) (*Iterator[ID], error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w, got %s", ErrNotStruct, typ.Kind())
+	}
+
+	components := make([]int, len(terms))
+	fields := make([]FieldValue, len(terms))
+	names := make(map[ComponentType]string, len(terms))
+
+	for i, term := range terms {
+		// filters may refer to the component accessed by another field, but a component may be accessed only once
+		if term.Access == Copy || term.Access == Direct || term.Access == Optional {
+			if other, ok := names[term.Component]; ok {
+				return nil, duplicateError(typ, other, term.Field.Name, term.Component)
+			}
+			names[term.Component] = term.Field.Name
+		}
+
+		fields[i] = FieldValue{
+			offset: term.Field.Offset,
+			access: term.Access,
+		}
+
+		components[i] = componentID(term.Component)
+	}
+
+	return &Iterator[ID]{
+		storage:    s,
+		typ:        typ,
+		fields:     fields,
+		components: components,
+	}, nil
+}
+
+// FieldValue is a field of the iterated bundle
+type FieldValue struct {
+	offset uintptr
+	access Access
+}
+
+type matchedTable[ID comparable] struct {
+	table   *Table[ID]
+	columns []*Column
+}
+
+// Iterator walks over all tables which have every required component of the bundle and have no excluded ones.
+// Every iteration gets a new change tick which is used to mark accessed components as changed
+type Iterator[ID comparable] struct {
+	storage *Storage[ID]
+	typ     reflect.Type
+
+	// scratch keeps a bundle for each worker, the callback gets a pointer to it
+	scratch []unsafe.Pointer
+
+	components []int
+	fields     []FieldValue
+
+	tables []matchedTable[ID]
+	seen   int
+
+	lastRun uint64
+}
+
+// match looks for the tables created since the previous call
+func (iter *Iterator[ID]) match() {
+	for _, table := range iter.storage.tables[iter.seen:] {
+		if columns, ok := iter.columns(table); ok {
+			iter.tables = append(iter.tables, matchedTable[ID]{
+				table:   table,
+				columns: columns,
+			})
+		}
+	}
+
+	iter.seen = len(iter.storage.tables)
+}
+
+// columns returns the table columns used by the fields. Only fields that access components get a column,
+// and optional fields get nil column if the table has no such component
+func (iter *Iterator[ID]) columns(table *Table[ID]) ([]*Column, bool) {
+	columns := make([]*Column, len(iter.components))
+
+	for i, component := range iter.components {
+		column := table.Column(component)
+
+		switch iter.fields[i].access {
+		case Copy, Direct, Added, Changed:
+			if column == nil {
+				return nil, false
+			}
+			columns[i] = column
+		case Optional:
+			columns[i] = column
+		case With:
+			if column == nil {
+				return nil, false
+			}
+		case Without:
+			if column != nil {
+				return nil, false
+			}
+		}
+	}
+
+	return columns, true
+}
+
+func (iter *Iterator[ID]) ForEach(f func(ID, unsafe.Pointer) bool) {
+	iter.match()
+	elem := iter.elems(1)[0]
+
+	thisRun := iter.storage.NextTick()
+	defer func() {
+		iter.lastRun = thisRun
+	}()
+
+	for _, matched := range iter.tables {
+		if !iter.forRows(elem, matched, 0, matched.table.Len(), thisRun, f) {
+			return
+		}
+	}
+}
+
+// Get fills a new bundle with the components of the entity. It returns nil if the entity doesn't exist or doesn't match
+// the bundle. Added and Changed fields are compared with the previous iteration
+func (iter *Iterator[ID]) Get(id ID) unsafe.Pointer {
+	loc, ok := iter.storage.entities[id]
+	if !ok {
+		return nil
+	}
+
+	columns, ok := iter.columns(loc.table)
+	if !ok {
+		return nil
+	}
+
+	matched := matchedTable[ID]{
+		table:   loc.table,
+		columns: columns,
+	}
+
+	elem := reflect.New(iter.typ).UnsafePointer()
+
+	var found bool
+	iter.forRows(elem, matched, loc.row, loc.row+1, iter.storage.NextTick(), func(ID, unsafe.Pointer) bool {
+		found = true
+		return true
+	})
+
+	if !found {
+		return nil
+	}
+
+	return elem
+}
+
+// ParForEach splits matched entities into batches of batchSize rows and processes them with workers goroutines.
+// Each worker has its own bundle, so the callback may be called concurrently
+func (iter *Iterator[ID]) ParForEach(f func(ID, unsafe.Pointer), batchSize, workers int) {
+	iter.match()
+	elems := iter.elems(workers)
+
+	thisRun := iter.storage.NextTick()
+	defer func() {
+		iter.lastRun = thisRun
+	}()
+
+	type batch struct {
+		matched  matchedTable[ID]
+		from, to int
+	}
+
+	batches := make(chan batch)
+
+	var wg sync.WaitGroup
+	for _, elem := range elems {
+		wg.Add(1)
+		go func(elem unsafe.Pointer) {
+			defer wg.Done()
+			for b := range batches {
+				iter.forRows(elem, b.matched, b.from, b.to, thisRun, func(id ID, ptr unsafe.Pointer) bool {
+					f(id, ptr)
+					return true
+				})
+			}
+		}(elem)
+	}
+
+	for _, matched := range iter.tables {
+		for from := 0; from < matched.table.Len(); from += batchSize {
+			to := from + batchSize
+			if to > matched.table.Len() {
+				to = matched.table.Len()
+			}
+			batches <- batch{matched: matched, from: from, to: to}
+		}
+	}
+	close(batches)
+	wg.Wait()
+}
+
+// elems returns scratch bundles for n workers
+func (iter *Iterator[ID]) elems(n int) []unsafe.Pointer {
+	for len(iter.scratch) < n {
+		iter.scratch = append(iter.scratch, reflect.New(iter.typ).UnsafePointer())
+	}
+
+	return iter.scratch[:n]
+}
+
+// forRows calls f for rows of the table in range [from, to) using elem as a bundle. It returns false if f stopped the iteration
+func (iter *Iterator[ID]) forRows(elem unsafe.Pointer, matched matchedTable[ID], from, to int, thisRun uint64, f func(ID, unsafe.Pointer) bool) bool {
+RowLoop:
+	for row := from; row < to; row++ {
+		for i, column := range matched.columns {
+			switch iter.fields[i].access {
+			case Added:
+				if column.added[row] <= iter.lastRun {
+					continue RowLoop
+				}
+			case Changed:
+				if column.changed[row] <= iter.lastRun {
+					continue RowLoop
+				}
+			}
+		}
+
+		for i, column := range matched.columns {
+			field := iter.fields[i]
+			ptr := unsafe.Add(elem, field.offset)
+
+			switch field.access {
+			case Copy:
+				column.load(row, ptr)
+			case Direct:
+				*(*unsafe.Pointer)(ptr) = column.Get(row)
+				column.changed[row] = thisRun
+			case Optional:
+				var component unsafe.Pointer
+				if column != nil {
+					component = column.Get(row)
+					column.changed[row] = thisRun
+				}
+				*(*unsafe.Pointer)(ptr) = component
+			}
+		}
+
+		if cont := f(matched.table.ids[row], elem); !cont {
+			return false
+		}
+
+		// copies are written back only if the callback modified them, so unmodified components aren't marked as changed
+		for i, column := range matched.columns {
+			field := iter.fields[i]
+			ptr := unsafe.Add(elem, field.offset)
+			if field.access == Copy && !equalPointer(column.Get(row), ptr, column.size) {
+				column.set(row, ptr)
+				column.changed[row] = thisRun
+			}
+		}
+	}
+
+	return true
+}
+
+func copyPointer(dst unsafe.Pointer, src unsafe.Pointer, size uintptr) {
+	srcSlice := unsafe.Slice((*byte)(src), size)
+	dstSlice := unsafe.Slice((*byte)(dst), size)
+
+	copy(dstSlice, srcSlice)
+}
+
+func equalPointer(a unsafe.Pointer, b unsafe.Pointer, size uintptr) bool {
+	return bytes.Equal(unsafe.Slice((*byte)(a), size), unsafe.Slice((*byte)(b), size))
+}
@@ -1,158 +1,310 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"unsafe"
 )
 
-type FieldType struct {
+var (
+	ErrEntityNotFound     = errors.New("entity not found")
+	ErrComponentNotFound  = errors.New("component not found")
+	ErrDuplicateComponent = errors.New("duplicate component")
+	ErrNotStruct          = errors.New("bundle type should be a struct")
+	ErrNilComponent       = errors.New("component should not be nil")
+	ErrPointerComponent   = errors.New("component should not be a pointer")
+)
+
+// ComponentType identifies a component. Components are identified by their Go type, Name is set only
+// for fields tagged with `herd:"name"` to store several components of the same type
+type ComponentType struct {
 	Name string
 	Type reflect.Type
 }
 
+// bundleComponents returns the component types of the bundle fields in field order. It fails if two fields
+// refer to the same component
+func bundleComponents(typ reflect.Type) ([]ComponentType, error) {
+	components := make([]ComponentType, typ.NumField())
+	fields := make(map[ComponentType]string, typ.NumField())
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		component := ComponentType{
+			Name: field.Tag.Get("herd"),
+			Type: field.Type,
+		}
+
+		if field.Type.Kind() == reflect.Pointer {
+			return nil, pointerError(field.Type, fmt.Sprintf("field %s of %s", field.Name, typ))
+		}
+
+		if other, ok := fields[component]; ok {
+			return nil, duplicateError(typ, other, field.Name, component)
+		}
+
+		fields[component] = field.Name
+		components[i] = component
+	}
+
+	return components, nil
+}
+
+func duplicateError(typ reflect.Type, first, second string, component ComponentType) error {
+	return fmt.Errorf("%w: fields %s and %s of %s have the same type %s, use `herd:\"name\"` tag to distinguish them",
+		ErrDuplicateComponent, first, second, typ, component.Type)
+}
+
+// pointerError explains that pointer fields of query bundles refer to components, so a pointer can't be a component itself
+func pointerError(typ reflect.Type, what string) error {
+	return fmt.Errorf("%w: %s has type %s, pointer fields of queries refer to components of type %s, so store %s by value",
+		ErrPointerComponent, what, typ, typ.Elem(), typ.Elem())
+}
+
+type location[ID comparable] struct {
+	table *Table[ID]
+	row   int
+}
+
+// Storage keeps entities in archetype tables. Entities with the same set of components share a table,
+// so components of such entities lay contiguously in the table columns
 type Storage[ID comparable] struct {
-	arrays map[FieldType]*SparseArray[ID]
+	tick uint64
 
-	fullIndex map[ID]struct{}
+	componentIDs map[ComponentType]int
+	components   []ComponentType
+
+	tables     []*Table[ID]
+	archetypes map[string]*Table[ID]
+
+	entities map[ID]location[ID]
 }
 
-func NewStorage[ID comparable]() Storage[ID] {
-	return Storage[ID]{
-		arrays:    make(map[FieldType]*SparseArray[ID]),
-		fullIndex: make(map[ID]struct{}),
+func NewStorage[ID comparable]() *Storage[ID] {
+	return &Storage[ID]{
+		componentIDs: make(map[ComponentType]int),
+		archetypes:   make(map[string]*Table[ID]),
+		entities:     make(map[ID]location[ID]),
 	}
 }
 
 func (s *Storage[ID]) Add(id ID, bundle any) error {
 	typ := reflect.TypeOf(bundle)
 
+	if typ == nil {
+		return fmt.Errorf("%w, got nil", ErrNotStruct)
+	}
+
 	if typ.Kind() != reflect.Struct {
-		return fmt.Errorf("bundle type should be a struct, got %s", typ.Kind())
+		return fmt.Errorf("%w, got %s", ErrNotStruct, typ.Kind())
 	}
 
-	for i := 0; i < typ.NumField(); i++ {
-		fieldType := typ.Field(i)
+	components, err := bundleComponents(typ)
+	if err != nil {
+		return err
+	}
+
+	// bundle is copied to an addressable value, so its fields may be read by offset
+	val := reflect.New(typ)
+	val.Elem().Set(reflect.ValueOf(bundle))
+	ptr := val.UnsafePointer()
 
-		array := s.sparseArray(fieldType.Name, fieldType.Type)
-		fieldPtr := unsafe.Add(toPointer(bundle), fieldType.Offset)
-		array.Add(id, fieldPtr)
+	ids := make([]int, len(components))
+	for i, component := range components {
+		ids[i] = s.componentID(component)
 	}
 
-	s.fullIndex[id] = struct{}{}
+	table := s.archetype(ids)
+	row := table.push(id)
+
+	tick := s.Tick()
+	for i := 0; i < typ.NumField(); i++ {
+		fieldPtr := unsafe.Add(ptr, typ.Field(i).Offset)
+		column := table.Column(ids[i])
+		column.set(row, fieldPtr)
+		column.stamp(row, tick)
+	}
+
+	s.entities[id] = location[ID]{table: table, row: row}
 
 	return nil
 }
 
-type iface struct {
-	Type, Data unsafe.Pointer
-}
+func (s *Storage[ID]) Remove(id ID) error {
+	loc, ok := s.entities[id]
+	if !ok {
+		return ErrEntityNotFound
+	}
 
-func toPointer(val any) unsafe.Pointer {
-	return (*iface)(unsafe.Pointer(&val)).Data
+	s.removeRow(loc)
+	delete(s.entities, id)
+
+	return nil
 }
 
-func (s *Storage[ID]) sparseArray(name string, typ reflect.Type) *SparseArray[ID] {
-	field := FieldType{
-		Name: name,
-		Type: typ,
+// Insert adds a single unnamed component to the existing entity
+func (s *Storage[ID]) Insert(id ID, component any) error {
+	loc, ok := s.entities[id]
+	if !ok {
+		return ErrEntityNotFound
 	}
 
-	if s.arrays[field] == nil {
-		s.arrays[field] = NewSparseArray[ID]()
+	typ := reflect.TypeOf(component)
+	if typ == nil {
+		return ErrNilComponent
 	}
 
-	return s.arrays[field]
-}
+	if typ.Kind() == reflect.Pointer {
+		return pointerError(typ, "inserted component")
+	}
 
-func (s *Storage[ID]) Count() int {
-	return len(s.fullIndex)
-}
+	val := reflect.New(typ)
+	val.Elem().Set(reflect.ValueOf(component))
 
-func (s *Storage[ID]) Iterator(typ reflect.Type) (Iterator[ID], error) {
-	if typ.Kind() != reflect.Struct {
-		return Iterator[ID]{}, fmt.Errorf("bundle type should be a struct, got %s", typ.Kind())
+	componentID := s.componentID(ComponentType{Type: typ})
+	if loc.table.has(componentID) {
+		column := loc.table.Column(componentID)
+		column.set(loc.row, val.UnsafePointer())
+		column.changed[loc.row] = s.Tick()
+
+		return nil
 	}
 
-	val := reflect.New(typ).Elem()
+	components := append([]int{componentID}, loc.table.components...)
+	loc = s.move(id, loc, s.archetype(components))
 
-	arrays := make([]*SparseArray[ID], val.NumField())
-	fields := make([]FieldValue, val.NumField())
+	column := loc.table.Column(componentID)
+	column.set(loc.row, val.UnsafePointer())
+	column.stamp(loc.row, s.Tick())
 
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := typ.Field(i)
+	return nil
+}
 
-		fields[i] = FieldValue{
-			pointer: unsafe.Add(val.Addr().UnsafePointer(), fieldType.Offset),
-			size:    fieldType.Type.Size(),
-		}
+// RemoveComponent removes a single unnamed component of the given type from the existing entity
+func (s *Storage[ID]) RemoveComponent(id ID, typ reflect.Type) error {
+	loc, ok := s.entities[id]
+	if !ok {
+		return ErrEntityNotFound
+	}
 
-		arrays[i] = s.sparseArray(typ.Field(i).Name, field.Type())
+	componentID, ok := s.componentIDs[ComponentType{Type: typ}]
+	if !ok || !loc.table.has(componentID) {
+		return ErrComponentNotFound
 	}
 
-	return Iterator[ID]{
-		elem:      val.Addr().UnsafePointer(),
-		fields:    fields,
-		arrays:    arrays,
-		fullIndex: s.fullIndex,
-	}, nil
+	components := make([]int, 0, len(loc.table.components)-1)
+	for _, component := range loc.table.components {
+		if component != componentID {
+			components = append(components, component)
+		}
+	}
+
+	s.move(id, loc, s.archetype(components))
+
+	return nil
 }
 
-type FieldValue struct {
-	pointer unsafe.Pointer
-	size    uintptr
+// move transfers the entity to another table, copying all components that both tables have
+func (s *Storage[ID]) move(id ID, loc location[ID], table *Table[ID]) location[ID] {
+	row := table.push(id)
+	for component, column := range table.columns {
+		if src := loc.table.Column(component); src != nil {
+			column.set(row, src.Get(loc.row))
+			column.added[row] = src.added[loc.row]
+			column.changed[row] = src.changed[loc.row]
+		}
+	}
+
+	s.removeRow(loc)
+
+	dst := location[ID]{table: table, row: row}
+	s.entities[id] = dst
+
+	return dst
 }
 
-type Iterator[ID comparable] struct {
-	elem unsafe.Pointer
+func (s *Storage[ID]) removeRow(loc location[ID]) {
+	if moved, ok := loc.table.swapRemove(loc.row); ok {
+		s.entities[moved] = loc
+	}
+}
 
-	arrays []*SparseArray[ID]
-	fields []FieldValue
+func (s *Storage[ID]) componentID(component ComponentType) int {
+	id, ok := s.componentIDs[component]
+	if !ok {
+		id = len(s.components)
+		s.components = append(s.components, component)
+		s.componentIDs[component] = id
+	}
 
-	fullIndex map[ID]struct{}
+	return id
 }
 
-func (iter Iterator[ID]) ForEach(f func(ID, unsafe.Pointer) bool) {
-	if len(iter.arrays) == 0 {
-		for id := range iter.fullIndex {
-			if cont := f(id, iter.elem); !cont {
-				break
-			}
-		}
-		return
+// Component returns a pointer to the component of the entity and marks the component as changed
+func (s *Storage[ID]) Component(id ID, component ComponentType) (unsafe.Pointer, bool) {
+	componentID, ok := s.componentIDs[component]
+	if !ok {
+		return nil, false
 	}
 
-	positions := make([]int, len(iter.arrays))
+	loc, ok := s.entities[id]
+	if !ok {
+		return nil, false
+	}
 
-EntityLoop:
-	for id, pos := range iter.arrays[0].index {
-		positions[0] = pos
-		for i, array := range iter.arrays {
-			pos, ok := array.index[id]
-			if !ok {
-				continue EntityLoop
-			}
-			positions[i] = pos
-		}
+	column := loc.table.Column(componentID)
+	if column == nil {
+		return nil, false
+	}
 
-		for i, pos := range positions {
-			copyPointer(iter.fields[i].pointer, iter.arrays[i].slice[pos], iter.fields[i].size)
-		}
+	column.changed[loc.row] = s.NextTick()
 
-		if cont := f(id, iter.elem); !cont {
-			break
-		}
+	return column.Get(loc.row), true
+}
 
-		for i, pos := range positions {
-			copyPointer(iter.arrays[i].slice[pos], iter.fields[i].pointer, iter.fields[i].size)
-		}
+// archetype returns the table for the given set of components, creating it if needed
+func (s *Storage[ID]) archetype(components []int) *Table[ID] {
+	sorted := append([]int(nil), components...)
+	sort.Ints(sorted)
+
+	var key strings.Builder
+	for _, component := range sorted {
+		key.WriteString(strconv.Itoa(component))
+		key.WriteByte(',')
 	}
+
+	table, ok := s.archetypes[key.String()]
+	if !ok {
+		table = newTable[ID](sorted, s.components)
+		s.archetypes[key.String()] = table
+		s.tables = append(s.tables, table)
+	}
+
+	return table
 }
 
-func copyPointer(dst unsafe.Pointer, src unsafe.Pointer, size uintptr) {
-	srcSlice := unsafe.Slice((*byte)(src), size)
-	dstSlice := unsafe.Slice((*byte)(dst), size)
+// Tick returns the current change tick. Components added or changed by structural changes are stamped with it
+func (s *Storage[ID]) Tick() uint64 {
+	return atomic.LoadUint64(&s.tick)
+}
+
+// NextTick advances the change tick and returns its new value
+func (s *Storage[ID]) NextTick() uint64 {
+	return atomic.AddUint64(&s.tick, 1)
+}
 
-	copy(dstSlice, srcSlice)
+// Contains reports whether the entity is in the storage
+func (s *Storage[ID]) Contains(id ID) bool {
+	_, ok := s.entities[id]
+
+	return ok
+}
+
+func (s *Storage[ID]) Count() int {
+	return len(s.entities)
 }
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Column is a contiguous array of components of the same type. Memory is allocated with reflect,
+// so columns of types containing pointers are visible to the garbage collector. For every row column
+// also keeps the ticks at which the component was added and changed last time
+type Column struct {
+	typ   reflect.Type
+	size  uintptr
+	slice reflect.Value
+	data  unsafe.Pointer
+	len   int
+
+	// components with pointers are copied with reflect, so the garbage collector sees the written pointers
+	pointers bool
+
+	added   []uint64
+	changed []uint64
+}
+
+func newColumn(typ reflect.Type) *Column {
+	return &Column{
+		typ:      typ,
+		size:     typ.Size(),
+		slice:    reflect.MakeSlice(reflect.SliceOf(typ), 0, 0),
+		pointers: hasPointers(typ),
+	}
+}
+
+// Get returns a pointer to the component at the row. Pointer stays valid until the next structural change of the table
+func (c *Column) Get(row int) unsafe.Pointer {
+	return unsafe.Add(c.data, uintptr(row)*c.size)
+}
+
+func (c *Column) set(row int, ptr unsafe.Pointer) {
+	c.copy(c.Get(row), ptr)
+}
+
+// load copies the component at the row to dst
+func (c *Column) load(row int, dst unsafe.Pointer) {
+	c.copy(dst, c.Get(row))
+}
+
+func (c *Column) copy(dst unsafe.Pointer, src unsafe.Pointer) {
+	if !c.pointers {
+		copyPointer(dst, src, c.size)
+		return
+	}
+
+	reflect.NewAt(c.typ, dst).Elem().Set(reflect.NewAt(c.typ, src).Elem())
+}
+
+// hasPointers reports whether values of typ contain pointers the garbage collector has to track
+func hasPointers(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func,
+		reflect.Interface, reflect.String:
+		return true
+	case reflect.Array:
+		return typ.Len() > 0 && hasPointers(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if hasPointers(typ.Field(i).Type) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// stamp marks the component at the row as added and changed at tick
+func (c *Column) stamp(row int, tick uint64) {
+	c.added[row] = tick
+	c.changed[row] = tick
+}
+
+func (c *Column) grow() int {
+	if c.len == c.slice.Cap() {
+		capacity := 2 * c.slice.Cap()
+		if capacity < 8 {
+			capacity = 8
+		}
+
+		slice := reflect.MakeSlice(c.slice.Type(), capacity, capacity)
+		reflect.Copy(slice, c.slice.Slice(0, c.len))
+
+		c.slice = slice
+		c.data = slice.UnsafePointer()
+	}
+
+	c.len++
+	c.added = append(c.added, 0)
+	c.changed = append(c.changed, 0)
+
+	return c.len - 1
+}
+
+func (c *Column) swapRemove(row int) {
+	last := c.len - 1
+	if row != last {
+		c.set(row, c.Get(last))
+		c.added[row] = c.added[last]
+		c.changed[row] = c.changed[last]
+	}
+
+	c.slice.Index(last).SetZero()
+	c.len--
+	c.added = c.added[:last]
+	c.changed = c.changed[:last]
+}
+
+// Table stores entities of one archetype: every entity in the table has the same set of components,
+// and each component is kept in its own column indexed by row
+type Table[ID comparable] struct {
+	components []int
+	columns    map[int]*Column
+	ids        []ID
+}
+
+func newTable[ID comparable](components []int, types []ComponentType) *Table[ID] {
+	columns := make(map[int]*Column, len(components))
+	for _, component := range components {
+		columns[component] = newColumn(types[component].Type)
+	}
+
+	return &Table[ID]{
+		components: components,
+		columns:    columns,
+	}
+}
+
+func (t *Table[ID]) Len() int {
+	return len(t.ids)
+}
+
+// Column returns a column of the component or nil if the table has no such component
+func (t *Table[ID]) Column(component int) *Column {
+	return t.columns[component]
+}
+
+func (t *Table[ID]) has(component int) bool {
+	_, ok := t.columns[component]
+
+	return ok
+}
+
+// push adds a new row with zero components for id and returns its number
+func (t *Table[ID]) push(id ID) int {
+	for _, column := range t.columns {
+		column.grow()
+	}
+	t.ids = append(t.ids, id)
+
+	return len(t.ids) - 1
+}
+
+// swapRemove deletes the row by moving the last row into its place. It returns the id that was moved
+// and whether any id was moved at all
+func (t *Table[ID]) swapRemove(row int) (ID, bool) {
+	for _, column := range t.columns {
+		column.swapRemove(row)
+	}
+
+	last := len(t.ids) - 1
+	moved := t.ids[last]
+	t.ids[row] = moved
+	t.ids = t.ids[:last]
+
+	return moved, row != last
+}
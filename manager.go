@@ -1,22 +1,159 @@
 package herd
 
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/elemir/herd/internal"
+)
+
+var (
+	// ErrNotStruct is returned when a bundle is not a struct
+	ErrNotStruct = internal.ErrNotStruct
+	// ErrDuplicateComponent is returned when several fields of a bundle refer to the same component
+	ErrDuplicateComponent = internal.ErrDuplicateComponent
+	// ErrEntityNotFound is returned when a command targets an entity that doesn't exist
+	ErrEntityNotFound = internal.ErrEntityNotFound
+	// ErrComponentNotFound is returned when a removed component doesn't exist
+	ErrComponentNotFound = internal.ErrComponentNotFound
+	// ErrNilComponent is returned when nil is inserted as a component
+	ErrNilComponent = internal.ErrNilComponent
+	// ErrPointerComponent is returned when a component is a pointer. Pointer fields of queries refer to components
+	// in the storage, so components are stored by value
+	ErrPointerComponent = internal.ErrPointerComponent
+)
+
+// SpawnError describes a bundle that can't be spawned
+type SpawnError struct {
+	Entity EntityID
+	Bundle reflect.Type
+	Err    error
+}
+
+func (e *SpawnError) Error() string {
+	if e.Bundle == nil {
+		return fmt.Sprintf("spawn entity %s with nil bundle: %s", e.Entity, e.Err)
+	}
+
+	return fmt.Sprintf("spawn entity %s with bundle %s: %s", e.Entity, e.Bundle, e.Err)
+}
+
+func (e *SpawnError) Unwrap() error {
+	return e.Err
+}
+
+// CommandError describes a queued despawn, insert, remove or state command that can't be applied, e.g. a despawn of
+// an entity that is already despawned by another system. Such commands are reported to the command error handler and
+// skipped, while the rest of the queue is applied
+type CommandError struct {
+	Entity EntityID
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// SpawnPolicy defines what App does when a queued bundle can't be spawned
+type SpawnPolicy int
+
+const (
+	// FailOnSpawnError fails the tick with SpawnError and discards the rest of the queue. App isn't closed, so the next
+	// Update runs as usual
+	FailOnSpawnError SpawnPolicy = iota
+	// SkipSpawnErrors reports SpawnError to the command error handler and skips the bundle together with other commands
+	// targeting its entity
+	SkipSpawnErrors
+)
+
+type commandKind int
+
+const (
+	spawnCommand commandKind = iota
+	despawnCommand
+	insertCommand
+	removeCommand
+	stateCommand
+)
+
+type command struct {
+	kind  commandKind
+	id    EntityID
+	value any
+	typ   reflect.Type
+}
+
+// Manager queues structural changes of the world. Queued commands are applied in order at the end of the current stage.
+// Manager is safe for concurrent use by systems running in parallel
 type Manager struct {
-	spawnQueue []any
+	mu       sync.Mutex
+	queue    []command
+	entities *entities
 }
 
-func newManager() *Manager {
-	spawnQueue := make([]any, 0, 32)
+func newManager(entities *entities) *Manager {
+	queue := make([]command, 0, 32)
 	return &Manager{
-		spawnQueue: spawnQueue,
+		queue:    queue,
+		entities: entities,
 	}
 }
 
-func (c *Manager) Spawn(bundle any) {
-	c.spawnQueue = append(c.spawnQueue, bundle)
+// Spawn queues adding a new entity with components of the bundle and returns its ID. The ID is reserved immediately,
+// so it may be used by other commands queued after Spawn, while the entity itself is added at the end of the current stage
+func (c *Manager) Spawn(bundle any) EntityID {
+	id := c.entities.allocate()
+
+	c.push(command{
+		kind:  spawnCommand,
+		id:    id,
+		value: bundle,
+	})
+
+	return id
+}
+
+// Despawn queues removal of the entity with all its components. Entity is removed at the end of the current stage.
+// Despawn of an entity which is already despawned is reported as CommandError and skipped
+func (c *Manager) Despawn(id EntityID) {
+	c.push(command{
+		kind: despawnCommand,
+		id:   id,
+	})
+}
+
+// Insert queues adding component to the entity. If the entity already has a component of the same type it is replaced
+func (c *Manager) Insert(id EntityID, component any) {
+	c.push(command{
+		kind:  insertCommand,
+		id:    id,
+		value: component,
+	})
+}
+
+// Remove queues removing component of type T from the entity
+func Remove[T any](c *Manager, id EntityID) {
+	c.push(command{
+		kind: removeCommand,
+		id:   id,
+		typ:  internal.TypeOf[T](),
+	})
+}
+
+func (c *Manager) push(cmd command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queue = append(c.queue, cmd)
 }
 
 func (c *Manager) clear() {
-	if len(c.spawnQueue) != 0 {
-		c.spawnQueue = make([]any, 0, 32)
+	if len(c.queue) != 0 {
+		c.queue = make([]command, 0, 32)
 	}
 }
@@ -2,23 +2,39 @@ package herd
 
 import (
 	"fmt"
+	"reflect"
+	"runtime"
 	"unsafe"
 
 	"github.com/elemir/herd/internal"
 )
 
+// Query iterates over all entities that have every component of bundle T. A value field of T is a copy of the component
+// which is written back after the callback, while a pointer field points directly to the component in the storage and
+// stays valid until the next structural change of its table. Commands are applied after every stage, including every
+// step of FixedUpdate, so pointers should not be kept longer than the current stage. Fields of types With, Without
+// and Optional filter entities by their components
 type Query[T any] struct {
-	iterator internal.Iterator[EntityID]
+	iterator *internal.Iterator[EntityID]
+	terms    []internal.Term
 }
 
 func NewQuery[T any](app *App) (Query[T], error) {
-	iterator, err := app.storage.Iterator(internal.TypeOf[T]())
+	typ := internal.TypeOf[T]()
+
+	terms, err := queryTerms(typ)
+	if err != nil {
+		return Query[T]{}, err
+	}
+
+	iterator, err := app.storage.Iterator(typ, terms)
 	if err != nil {
 		return Query[T]{}, fmt.Errorf("create iterator: %w", err)
 	}
 
 	return Query[T]{
 		iterator: iterator,
+		terms:    terms,
 	}, nil
 }
 
@@ -35,3 +51,92 @@ func (q Query[T]) Iterate(f func(id EntityID, t *T) bool) {
 		return f(id, (*T)(ptr))
 	})
 }
+
+// Get returns the bundle of the entity or false if the entity doesn't exist or doesn't match the query. Value fields
+// of the bundle are copies which aren't written back, so components should be modified through pointer fields
+func (q Query[T]) Get(id EntityID) (*T, bool) {
+	ptr := q.iterator.Get(id)
+	if ptr == nil {
+		return nil, false
+	}
+
+	return (*T)(ptr), true
+}
+
+// Get returns a pointer to the component T of the entity and marks the component as changed. If the entity has no
+// component T, but T is a struct, T is looked up as a query bundle like Query.Get does, so value fields of the bundle
+// are copies and Added and Changed fields only require their components. Get returns false if the entity doesn't
+// exist or has neither. Get panics if T is an invalid query bundle, e.g. it accesses the same component twice
+func Get[T any](app *App, id EntityID) (*T, bool) {
+	typ := internal.TypeOf[T]()
+
+	if ptr, ok := app.storage.Component(id, internal.ComponentType{Type: typ}); ok {
+		return (*T)(ptr), true
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	iterator, err := app.bundle(typ)
+	if err != nil {
+		panic(fmt.Sprintf("get bundle %s: %v", typ, err))
+	}
+
+	ptr := iterator.Get(id)
+	if ptr == nil {
+		return nil, false
+	}
+
+	return (*T)(ptr), true
+}
+
+// bundle returns an iterator for looking up bundles of type typ. Iterators are cached once all components of
+// the bundle are known, so they are built once per bundle type
+func (app *App) bundle(typ reflect.Type) (*internal.Iterator[EntityID], error) {
+	app.bundlesMu.Lock()
+	defer app.bundlesMu.Unlock()
+
+	if iterator, ok := app.bundles[typ]; ok {
+		return iterator, nil
+	}
+
+	terms, err := queryTerms(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, complete, err := app.storage.Lookup(typ, terms)
+	if err != nil {
+		return nil, err
+	}
+
+	if complete {
+		app.bundles[typ] = iterator
+	}
+
+	return iterator, nil
+}
+
+// ParOptions configures ParForEach. Zero values mean defaults
+type ParOptions struct {
+	// BatchSize is a number of entities processed by a worker at once, 256 by default
+	BatchSize int
+	// Workers is a number of goroutines processing batches, GOMAXPROCS by default
+	Workers int
+}
+
+// ParForEach works like ForEach, but processes matched entities in batches by a pool of workers, so f must be safe
+// for concurrent use. Each worker gets its own copy of bundle, so value fields are not shared between workers
+func (q Query[T]) ParForEach(f func(t *T), opts ParOptions) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 256
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+
+	q.iterator.ParForEach(func(_ EntityID, ptr unsafe.Pointer) {
+		f((*T)(ptr))
+	}, opts.BatchSize, opts.Workers)
+}
@@ -0,0 +1,55 @@
+package herd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elemir/herd/internal"
+)
+
+var ErrResourceNotFound = errors.New("resource not found")
+
+// InsertResource stores value as a singleton resource of type T. If the App already has such a resource
+// it is overwritten in place, so pointers returned by Resource stay valid
+func InsertResource[T any](app *App, value T) {
+	typ := internal.TypeOf[T]()
+
+	if res, ok := app.resources[typ]; ok {
+		*res.(*T) = value
+		return
+	}
+
+	app.resources[typ] = &value
+}
+
+// Resource returns a pointer to the resource of type T
+func Resource[T any](app *App) (*T, error) {
+	typ := internal.TypeOf[T]()
+
+	res, ok := app.resources[typ]
+	if !ok {
+		return nil, fmt.Errorf("get resource %s: %w", typ, ErrResourceNotFound)
+	}
+
+	return res.(*T), nil
+}
+
+// RemoveResource deletes the resource of type T from the App
+func RemoveResource[T any](app *App) error {
+	typ := internal.TypeOf[T]()
+
+	if _, ok := app.resources[typ]; !ok {
+		return fmt.Errorf("remove resource %s: %w", typ, ErrResourceNotFound)
+	}
+
+	delete(app.resources, typ)
+
+	return nil
+}
+
+// HasResource reports whether the App has a resource of type T
+func HasResource[T any](app *App) bool {
+	_, ok := app.resources[internal.TypeOf[T]()]
+
+	return ok
+}
@@ -0,0 +1,421 @@
+package herd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Stage is a group of systems that run together. Stages run in the order they are declared
+// and commands queued by the systems of a stage are applied before the next stage starts
+type Stage int
+
+const (
+	// PreUpdate is for systems that prepare the tick, e.g. read input
+	PreUpdate Stage = iota
+	// FixedUpdate is for the simulation that runs with fixed time step, it may run several times per tick or not run at all.
+	// See FixedTime resource
+	FixedUpdate
+	// Update is for the game logic, systems added with App.AddSystems run here
+	Update
+	// PostUpdate is for systems that resolve results of the game logic, e.g. collisions
+	PostUpdate
+	// Last is for bookkeeping at the end of the tick
+	Last
+
+	stageCount int = iota
+)
+
+func (s Stage) String() string {
+	switch s {
+	case PreUpdate:
+		return "PreUpdate"
+	case FixedUpdate:
+		return "FixedUpdate"
+	case Update:
+		return "Update"
+	case PostUpdate:
+		return "PostUpdate"
+	case Last:
+		return "Last"
+	default:
+		return fmt.Sprintf("Stage(%d)", int(s))
+	}
+}
+
+func (s Stage) valid() bool {
+	return s >= 0 && int(s) < stageCount
+}
+
+// SystemConfig describes a system together with its label, ordering constraints, run conditions and access to data
+type SystemConfig struct {
+	system     System
+	label      string
+	before     []string
+	after      []string
+	conditions []*condition
+	holders    []any
+	access     *access
+}
+
+// Configure returns a config of the system, so it may be labelled and ordered relative to other systems of the stage
+func Configure(system System) *SystemConfig {
+	return &SystemConfig{
+		system: system,
+	}
+}
+
+// Label sets the label other systems of the stage may refer to. Labels must be unique in a stage. Labelled systems
+// with conflicting access must be ordered by Before or After, otherwise the stage fails with an ambiguity error
+func (c *SystemConfig) Label(label string) *SystemConfig {
+	c.label = label
+
+	return c
+}
+
+// Before requires the system to run before the systems with given labels
+func (c *SystemConfig) Before(labels ...string) *SystemConfig {
+	c.before = append(c.before, labels...)
+
+	return c
+}
+
+// After requires the system to run after the systems with given labels
+func (c *SystemConfig) After(labels ...string) *SystemConfig {
+	c.after = append(c.after, labels...)
+
+	return c
+}
+
+// RunIf requires all the conditions to be true for the system to run
+func (c *SystemConfig) RunIf(conditions ...Condition) *SystemConfig {
+	c.conditions = append(c.conditions, newConditions(conditions)...)
+
+	return c
+}
+
+// Access declares the data the system uses, so systems with disjoint access may run concurrently. Access is derived
+// from holders: queries, events, event readers and pointers to resources, or structs, slices, maps and interfaces
+// holding them like system structs do. A system without declared access, or with access that is empty or holds
+// functions or channels, runs exclusively. A system declaring access must not use anything else, except reading
+// resources with Resource. Systems queueing commands must declare Manager, so their commands and spawned entity IDs
+// don't depend on the scheduling of goroutines
+func (c *SystemConfig) Access(holders ...any) *SystemConfig {
+	c.holders = append(c.holders, holders...)
+
+	return c
+}
+
+func (c *SystemConfig) resolveAccess(app *App) {
+	if len(c.holders) == 0 {
+		return
+	}
+
+	c.access = newAccess()
+	for _, holder := range c.holders {
+		c.access.collect(app, holder)
+	}
+
+	// access that can't be derived or is empty most likely misses something, so the system runs exclusively
+	if c.access.opaque || len(c.access.reads)+len(c.access.writes) == 0 {
+		c.access = nil
+	}
+}
+
+func (c *SystemConfig) shouldRun(pass uint64) bool {
+	for _, condition := range c.conditions {
+		if !condition.evaluate(pass) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *SystemConfig) name(i int) string {
+	if c.label != "" {
+		return fmt.Sprintf("%q", c.label)
+	}
+
+	return fmt.Sprintf("#%d", i)
+}
+
+type schedule struct {
+	stage   Stage
+	configs []*SystemConfig
+	sorted  bool
+
+	// waves are groups of systems that may run concurrently. Waves run one by one
+	waves [][]*SystemConfig
+}
+
+func newSchedules() []schedule {
+	schedules := make([]schedule, stageCount)
+	for i := range schedules {
+		schedules[i].stage = Stage(i)
+	}
+
+	return schedules
+}
+
+func (s *schedule) add(configs ...*SystemConfig) {
+	s.configs = append(s.configs, configs...)
+	s.sorted = false
+}
+
+func (s *schedule) run(app *App, pass uint64) error {
+	if !s.sorted {
+		if err := s.sort(app); err != nil {
+			return fmt.Errorf("sort systems of stage %s: %w", s.stage, err)
+		}
+	}
+
+	systems := make([]*SystemConfig, 0, len(s.configs))
+
+	for _, wave := range s.waves {
+		systems = systems[:0]
+		for _, config := range wave {
+			if config.shouldRun(pass) {
+				systems = append(systems, config)
+			}
+		}
+
+		if err := runConcurrently(systems, app.workers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runConcurrently runs systems using up to workers goroutines and returns the error of the first failed system
+func runConcurrently(systems []*SystemConfig, workers int) error {
+	if len(systems) == 1 || workers <= 1 {
+		for _, config := range systems {
+			if err := config.system(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	errs := make([]error, len(systems))
+	tasks := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(systems); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				errs[task] = systems[task].system()
+			}
+		}()
+	}
+
+	for i := range systems {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sort orders systems topologically by their constraints. Systems that are not constrained keep the order they were added,
+// unless they are labelled and have conflicting access, see checkAmbiguity.
+// Then each system is placed to the wave after the waves of all systems it is constrained by or conflicts with,
+// so running waves one by one gives the same result as running sorted systems sequentially. Systems with run
+// conditions separate waves, so conditions see everything written by the systems before them
+func (s *schedule) sort(app *App) error {
+	labels := make(map[string]int, len(s.configs))
+	for i, config := range s.configs {
+		if config.label == "" {
+			continue
+		}
+		if other, ok := labels[config.label]; ok {
+			return fmt.Errorf("systems #%d and #%d have the same label %q", other, i, config.label)
+		}
+		labels[config.label] = i
+	}
+
+	edges := make([][]int, len(s.configs))
+	degree := make([]int, len(s.configs))
+
+	addEdge := func(from, to int) {
+		edges[from] = append(edges[from], to)
+		degree[to]++
+	}
+
+	for i, config := range s.configs {
+		for _, label := range config.before {
+			j, ok := labels[label]
+			if !ok {
+				return fmt.Errorf("system %s should run before unknown system %q", config.name(i), label)
+			}
+			addEdge(i, j)
+		}
+		for _, label := range config.after {
+			j, ok := labels[label]
+			if !ok {
+				return fmt.Errorf("system %s should run after unknown system %q", config.name(i), label)
+			}
+			addEdge(j, i)
+		}
+	}
+
+	done := make([]bool, len(s.configs))
+	order := make([]int, 0, len(s.configs))
+
+	for len(order) < len(s.configs) {
+		next := -1
+		for i := range s.configs {
+			if !done[i] && degree[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next == -1 {
+			return s.cycleError(edges, done)
+		}
+
+		done[next] = true
+		order = append(order, next)
+		for _, j := range edges[next] {
+			degree[j]--
+		}
+	}
+
+	for _, config := range s.configs {
+		config.resolveAccess(app)
+	}
+
+	if err := s.checkAmbiguity(edges, order); err != nil {
+		return err
+	}
+
+	waveOf := make([]int, len(s.configs))
+	s.waves = s.waves[:0]
+
+	// conditions of a wave are evaluated before its systems run, so a system with conditions starts a new wave after
+	// all previous systems, and the following systems never run before its conditions are evaluated
+	barrier := 0
+
+	for k, i := range order {
+		conditional := len(s.configs[i].conditions) > 0
+
+		wave := barrier
+		for _, prev := range order[:k] {
+			if waveOf[prev] >= wave && (conditional || s.configs[prev].access.conflicts(s.configs[i].access) ||
+				constrained(edges, prev, i)) {
+				wave = waveOf[prev] + 1
+			}
+		}
+
+		if conditional {
+			barrier = wave
+		}
+
+		waveOf[i] = wave
+		if wave == len(s.waves) {
+			s.waves = append(s.waves, nil)
+		}
+		s.waves[wave] = append(s.waves[wave], s.configs[i])
+	}
+
+	s.sorted = true
+
+	return nil
+}
+
+// checkAmbiguity reports labelled systems whose declared access conflicts, but which have no ordering path between them,
+// so their order would silently depend on the order they were added. Labelling a system opts it into explicit ordering,
+// while unlabelled systems and systems without declared access keep the order they were added
+func (s *schedule) checkAmbiguity(edges [][]int, order []int) error {
+	// reachable[i][j] is true if system i runs before system j because of constraints
+	reachable := make([][]bool, len(s.configs))
+	for k := len(order) - 1; k >= 0; k-- {
+		i := order[k]
+		reachable[i] = make([]bool, len(s.configs))
+		for _, j := range edges[i] {
+			reachable[i][j] = true
+			for l, ok := range reachable[j] {
+				reachable[i][l] = reachable[i][l] || ok
+			}
+		}
+	}
+
+	for k, i := range order {
+		for _, j := range order[k+1:] {
+			first, second := s.configs[i], s.configs[j]
+			if first.label == "" || second.label == "" || first.access == nil || second.access == nil {
+				continue
+			}
+
+			if first.access.conflicts(second.access) && !reachable[i][j] && !reachable[j][i] {
+				return fmt.Errorf("systems %s and %s access the same data, but their order is ambiguous, "+
+					"add Before or After constraint", first.name(i), second.name(j))
+			}
+		}
+	}
+
+	return nil
+}
+
+func constrained(edges [][]int, from, to int) bool {
+	for _, j := range edges[from] {
+		if j == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cycleError finds a cycle among the systems left after sorting. Each of them waits for another system left,
+// so walking back along the constraints always ends up in a cycle
+func (s *schedule) cycleError(edges [][]int, done []bool) error {
+	previous := make([]int, len(s.configs))
+	for from, targets := range edges {
+		if done[from] {
+			continue
+		}
+		for _, to := range targets {
+			previous[to] = from
+		}
+	}
+
+	current := 0
+	for done[current] {
+		current++
+	}
+
+	visited := make(map[int]int)
+	var path []int
+
+	for {
+		if pos, ok := visited[current]; ok {
+			path = append(path[pos:], current)
+			break
+		}
+
+		visited[current] = len(path)
+		path = append(path, current)
+		current = previous[current]
+	}
+
+	names := make([]string, len(path))
+	for i, system := range path {
+		names[len(path)-1-i] = s.configs[system].name(system)
+	}
+
+	return fmt.Errorf("systems have cyclic order: %s", strings.Join(names, " -> "))
+}
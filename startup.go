@@ -0,0 +1,233 @@
+package herd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrStartupTimeout is returned when a startup doesn't finish before its timeout
+var ErrStartupTimeout = errors.New("startup timed out")
+
+// StartupConfig describes a startup together with its name, dependencies, timeout and progress
+type StartupConfig struct {
+	startup  Startup
+	name     string
+	after    []string
+	timeout  time.Duration
+	progress func() float64
+}
+
+// ConfigureStartup returns a config of the startup
+func ConfigureStartup(startup Startup) *StartupConfig {
+	return &StartupConfig{
+		startup: startup,
+	}
+}
+
+// Name sets the name other startups may depend on. Names must be unique
+func (c *StartupConfig) Name(name string) *StartupConfig {
+	c.name = name
+
+	return c
+}
+
+// After makes the startup wait until the startups with given names finish
+func (c *StartupConfig) After(names ...string) *StartupConfig {
+	c.after = append(c.after, names...)
+
+	return c
+}
+
+// Timeout fails the App if the startup doesn't finish in d of real time after its first run
+func (c *StartupConfig) Timeout(d time.Duration) *StartupConfig {
+	c.timeout = d
+
+	return c
+}
+
+// Progress sets a function reporting progress of the startup in range [0, 1]. It is called every tick until the startup finishes
+func (c *StartupConfig) Progress(progress func() float64) *StartupConfig {
+	c.progress = progress
+
+	return c
+}
+
+func (c *StartupConfig) describe(i int) string {
+	if c.name != "" {
+		return fmt.Sprintf("%q", c.name)
+	}
+
+	return fmt.Sprintf("#%d", i)
+}
+
+// LoadingProgress is a resource with aggregated progress of startups. Renderers may read it to draw a loading screen
+// while App is initializing
+type LoadingProgress struct {
+	// Progress is an average progress of all startups in range [0, 1]
+	Progress float64
+	Finished int
+	Total    int
+}
+
+type startupInfo struct {
+	config   *StartupConfig
+	finished bool
+	started  time.Time
+	progress float64
+}
+
+// AddConfiguredStartups adds configured startups. Dependencies are checked when startups run first time
+func (app *App) AddConfiguredStartups(configs ...*StartupConfig) error {
+	for _, config := range configs {
+		app.startups = append(app.startups, startupInfo{
+			config: config,
+		})
+	}
+	app.startupsChecked = false
+
+	return nil
+}
+
+// runStartups runs every unfinished startup whose dependencies are finished and reports whether all startups are finished
+func (app *App) runStartups(now time.Time) (bool, error) {
+	if !app.startupsChecked {
+		if err := app.checkStartups(); err != nil {
+			return false, err
+		}
+		app.startupsChecked = true
+	}
+
+	initialized := true
+
+	for i := range app.startups {
+		startup := &app.startups[i]
+		if startup.finished {
+			continue
+		}
+
+		if !app.startupReady(startup.config) {
+			initialized = false
+			continue
+		}
+
+		if startup.started.IsZero() {
+			startup.started = now
+		}
+
+		finished, err := startup.config.startup()
+		if err != nil {
+			return false, err
+		}
+
+		startup.finished = finished
+		initialized = initialized && finished
+		switch {
+		case finished:
+			startup.progress = 1
+		case startup.config.progress != nil:
+			startup.progress = startup.config.progress()
+		}
+
+		if !finished && startup.config.timeout > 0 && now.Sub(startup.started) >= startup.config.timeout {
+			return false, fmt.Errorf("startup %s: %w after %s", startup.config.describe(i), ErrStartupTimeout, startup.config.timeout)
+		}
+	}
+
+	app.updateLoadingProgress()
+
+	return initialized, nil
+}
+
+func (app *App) startupReady(config *StartupConfig) bool {
+	for _, name := range config.after {
+		if !app.startups[app.startupNames[name]].finished {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (app *App) updateLoadingProgress() {
+	progress := LoadingProgress{
+		Progress: 1,
+		Total:    len(app.startups),
+	}
+
+	var sum float64
+	for _, startup := range app.startups {
+		if startup.finished {
+			progress.Finished++
+		}
+		sum += startup.progress
+	}
+
+	if progress.Total > 0 {
+		progress.Progress = sum / float64(progress.Total)
+	}
+
+	InsertResource(app, progress)
+}
+
+// checkStartups validates names and dependencies of startups, so that every startup may eventually run
+func (app *App) checkStartups() error {
+	app.startupNames = make(map[string]int, len(app.startups))
+	for i, startup := range app.startups {
+		name := startup.config.name
+		if name == "" {
+			continue
+		}
+		if other, ok := app.startupNames[name]; ok {
+			return fmt.Errorf("startups #%d and #%d have the same name %q", other, i, name)
+		}
+		app.startupNames[name] = i
+	}
+
+	for i, startup := range app.startups {
+		for _, name := range startup.config.after {
+			if _, ok := app.startupNames[name]; !ok {
+				return fmt.Errorf("startup %s depends on unknown startup %q", startup.config.describe(i), name)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(app.startups))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, app.startups[i].config.describe(i))
+
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("startups have cyclic dependencies: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[i] = visiting
+		for _, name := range app.startups[i].config.after {
+			if err := visit(app.startupNames[name], path); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range app.startups {
+		if err := visit(i, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
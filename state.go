@@ -0,0 +1,175 @@
+package herd
+
+import (
+	"fmt"
+
+	"github.com/elemir/herd/internal"
+)
+
+// State is a resource holding the current state of type S. Transitions are requested with SetState
+// and applied at the start of the next App.Update, before PreUpdate stage
+type State[S comparable] struct {
+	current S
+	next    S
+	pending bool
+}
+
+// Get returns the current state
+func (s *State[S]) Get() S {
+	return s.current
+}
+
+type stateMachine interface {
+	request(next any)
+	transition() error
+}
+
+type states[S comparable] struct {
+	state   *State[S]
+	entered bool
+
+	enter map[S][]System
+	exit  map[S][]System
+}
+
+func (m *states[S]) request(next any) {
+	m.state.next = next.(S)
+	m.state.pending = true
+}
+
+// transition runs OnEnter systems of the initial state on the first call, and OnExit and OnEnter systems
+// of the requested transition later on
+func (m *states[S]) transition() error {
+	if !m.entered {
+		m.entered = true
+		return runSystems(m.enter[m.state.current])
+	}
+
+	if !m.state.pending {
+		return nil
+	}
+
+	m.state.pending = false
+	if m.state.next == m.state.current {
+		return nil
+	}
+
+	if err := runSystems(m.exit[m.state.current]); err != nil {
+		return err
+	}
+
+	m.state.current = m.state.next
+
+	return runSystems(m.enter[m.state.current])
+}
+
+func runSystems(systems []System) error {
+	for _, system := range systems {
+		if err := system(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddState registers a state machine with states of type S starting in the initial state. The state is available as State[S] resource
+func AddState[S comparable](app *App, initial S) error {
+	typ := internal.TypeOf[State[S]]()
+	if _, ok := app.states[typ]; ok {
+		return fmt.Errorf("state %s is already added", typ)
+	}
+
+	InsertResource(app, State[S]{current: initial})
+	state, err := Resource[State[S]](app)
+	if err != nil {
+		return err
+	}
+
+	app.states[typ] = &states[S]{
+		state: state,
+		enter: make(map[S][]System),
+		exit:  make(map[S][]System),
+	}
+	app.stateOrder = append(app.stateOrder, typ)
+
+	return nil
+}
+
+func stateMachineOf[S comparable](app *App) (*states[S], error) {
+	typ := internal.TypeOf[State[S]]()
+
+	machine, ok := app.states[typ]
+	if !ok {
+		return nil, fmt.Errorf("state %s is not added", typ)
+	}
+
+	return machine.(*states[S]), nil
+}
+
+// OnEnter adds systems that run once when the state machine enters the state
+func OnEnter[S comparable](app *App, state S, systems ...System) error {
+	machine, err := stateMachineOf[S](app)
+	if err != nil {
+		return err
+	}
+
+	machine.enter[state] = append(machine.enter[state], systems...)
+
+	return nil
+}
+
+// OnExit adds systems that run once when the state machine exits the state
+func OnExit[S comparable](app *App, state S, systems ...System) error {
+	machine, err := stateMachineOf[S](app)
+	if err != nil {
+		return err
+	}
+
+	machine.exit[state] = append(machine.exit[state], systems...)
+
+	return nil
+}
+
+// OnUpdate adds systems to the stage that run only while the state machine is in the state
+func OnUpdate[S comparable](app *App, stage Stage, state S, systems ...System) error {
+	if _, err := stateMachineOf[S](app); err != nil {
+		return err
+	}
+
+	configs := make([]*SystemConfig, len(systems))
+	for i, system := range systems {
+		configs[i] = Configure(system)
+	}
+
+	return app.AddConfiguredSystems(stage, Group(configs...).RunIf(InState(app, state))...)
+}
+
+// InState is true while the state machine is in the state
+func InState[S comparable](app *App, state S) Condition {
+	return func() bool {
+		current, err := Resource[State[S]](app)
+
+		return err == nil && current.Get() == state
+	}
+}
+
+// SetState queues a transition of the state machine to the next state. Transition is applied at the start of the next tick
+func SetState[S comparable](c *Manager, next S) {
+	c.push(command{
+		kind:  stateCommand,
+		value: next,
+		typ:   internal.TypeOf[State[S]](),
+	})
+}
+
+// transition applies requested transitions of all state machines in the order they were added
+func (app *App) transition() error {
+	for _, typ := range app.stateOrder {
+		if err := app.states[typ].transition(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
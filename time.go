@@ -0,0 +1,83 @@
+package herd
+
+import (
+	"time"
+)
+
+// Clock is a source of real time. It may be replaced with App.SetClock to advance time deterministically in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Time is a resource updated at the start of every App.Update. Delta and Elapsed are measured by a virtual clock
+// that runs Scale times faster than real time and stops while Paused
+type Time struct {
+	// Tick is a number of the current App.Update call, starting from 1
+	Tick uint64
+
+	Delta   time.Duration
+	Elapsed time.Duration
+
+	RealDelta   time.Duration
+	RealElapsed time.Duration
+
+	Scale  float64
+	Paused bool
+}
+
+// DeltaSeconds returns Delta in seconds
+func (t *Time) DeltaSeconds() float64 {
+	return t.Delta.Seconds()
+}
+
+func (t *Time) advance(real time.Duration) {
+	t.Tick++
+	t.RealDelta = real
+	t.RealElapsed += real
+
+	t.Delta = 0
+	if !t.Paused {
+		t.Delta = time.Duration(float64(real) * t.Scale)
+	}
+	t.Elapsed += t.Delta
+}
+
+// FixedTime is a resource that configures FixedUpdate stage. Virtual time elapsed between ticks is accumulated,
+// and FixedUpdate runs once for every Step of the accumulated time, but no more than MaxSteps times per tick
+type FixedTime struct {
+	Step     time.Duration
+	MaxSteps int
+
+	// Alpha is a part of Step accumulated but not simulated yet, in range [0, 1). Renderers may use it
+	// to interpolate between the two last fixed steps
+	Alpha float64
+
+	accumulated time.Duration
+}
+
+// steps adds elapsed time to the accumulator and returns a number of fixed steps to run. Time that
+// can't be caught up with MaxSteps steps is dropped
+func (t *FixedTime) steps(elapsed time.Duration) int {
+	if t.Step <= 0 {
+		return 0
+	}
+
+	t.accumulated += elapsed
+
+	steps := int(t.accumulated / t.Step)
+	if t.MaxSteps > 0 && steps > t.MaxSteps {
+		steps = t.MaxSteps
+		t.accumulated = t.Step*time.Duration(steps) + t.accumulated%t.Step
+	}
+
+	t.accumulated -= t.Step * time.Duration(steps)
+	t.Alpha = float64(t.accumulated) / float64(t.Step)
+
+	return steps
+}